@@ -0,0 +1,464 @@
+package lang
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync/atomic"
+)
+
+var NUMBERS_ANY = []FunctionType{
+    FunctionType{Type: ExprNumber, QType: QuantityAny},
+}
+var NUMBERS_SOME = []FunctionType{
+    FunctionType{Type: ExprNumber, QType: QuantityRange, From: 1, To: QUANTITY_UNBOUNDED},
+}
+var NUMBER_ONE = []FunctionType{
+    FunctionType{Type: ExprNumber, QType: QuantityRegular},
+}
+var NUMBER_TWO = []FunctionType{
+    FunctionType{Type: ExprNumber, QType: QuantityRegular},
+    FunctionType{Type: ExprNumber, QType: QuantityRegular},
+}
+
+var ANY_SOME = []FunctionType{
+    FunctionType{Type: ExprAny, QType: QuantityRange, From: 1, To: QUANTITY_UNBOUNDED},
+}
+var FUNC_AND_COLL = []FunctionType{
+    FunctionType{Type: ExprFunc, QType: QuantityRegular},
+    FunctionType{Type: ExprColl, QType: QuantityRegular},
+}
+
+// BUILTINS are the functions every DefaultRegistry starts with.
+var BUILTINS = []Function {
+    Function{
+        Id: "+",
+        Types: NUMBERS_ANY,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return foldNums(intExpr(0), args, numAdd)
+        },
+    },
+    concatFunction(ExprStr),
+    concatFunction(ExprVector),
+    concatFunction(ExprList),
+    Function{
+        Id: "*",
+        Types: NUMBERS_ANY,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return foldNums(intExpr(1), args, numMul)
+        },
+    },
+    Function{
+        Id: "-",
+        Types: NUMBERS_SOME,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            if len(args) == 1 { return numNeg(args[0]) }
+            return foldNums(args[0], args[1:], numSub)
+        },
+    },
+    Function{
+        Id: "/",
+        Types: NUMBERS_SOME,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            if len(args) == 1 { return numDiv(intExpr(1), args[0]) }
+            return foldNums(args[0], args[1:], numDiv)
+        },
+    },
+    Function{
+        Id: "quot",
+        Types: NUMBER_TWO,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return numQuot(args[0], args[1])
+        },
+    },
+    Function{
+        Id: "rem",
+        Types: NUMBER_TWO,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return numRem(args[0], args[1])
+        },
+    },
+    Function{
+        Id: "mod",
+        Types: NUMBER_TWO,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return numMod(args[0], args[1])
+        },
+    },
+    Function{
+        Id: "abs",
+        Types: NUMBER_ONE,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return numAbs(args[0])
+        },
+    },
+    Function{
+        Id: "min",
+        Types: NUMBERS_SOME,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return pickNum(args, func(cmp int) bool { return cmp < 0 })
+        },
+    },
+    Function{
+        Id: "max",
+        Types: NUMBERS_SOME,
+        Returns: ExprNumber,
+        Impl: func(args []Expr) (Expr, error) {
+            return pickNum(args, func(cmp int) bool { return cmp > 0 })
+        },
+    },
+    Function{
+        Id: "vector",
+        Types: []FunctionType{
+            FunctionType{Type: ExprAny, QType: QuantityAny},
+        },
+        Returns: ExprVector,
+        Impl: func(args []Expr) (Expr, error) {
+            return vectorExpr(append([]Expr(nil), args...)), nil
+        },
+    },
+    Function{
+        Id: "hash-map",
+        Types: []FunctionType{
+            FunctionType{Type: ExprAny, QType: QuantityAny},
+        },
+        Returns: ExprMap,
+        Impl: func(args []Expr) (Expr, error) {
+            if len(args) % 2 != 0 {
+                return Expr{}, fmt.Errorf("hash-map expects key/value pairs, got %d arguments", len(args))
+            }
+            m := NewMap()
+            for i := 0; i < len(args); i += 2 {
+                if err := m.Set(args[i], args[i + 1]); err != nil { return Expr{}, err }
+            }
+            return mapExpr(m), nil
+        },
+    },
+    Function{
+        Id: "length",
+        Types: []FunctionType{
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+        },
+        Returns: ExprInt,
+        Impl: func(args []Expr) (Expr, error) {
+            return intExpr(int64(args[0].length())), nil
+        },
+    },
+    Function{
+        Id: "get",
+        Types: []FunctionType{
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityRange, From: 0, To: 1},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (Expr, error) {
+            value, ok, err := args[0].index(args[1])
+            if err != nil || ok { return value, err }
+            if len(args) == 3 { return args[2], nil }
+            return Expr{Type: ExprNil}, nil
+        },
+    },
+    Function{
+        Id: "nth",
+        Types: []FunctionType{
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+            FunctionType{Type: ExprInt,  QType: QuantityRegular},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (Expr, error) {
+            if args[0].Type == ExprMap {
+                return Expr{}, fmt.Errorf("nth expects a sequence, got map")
+            }
+            value, ok, err := args[0].index(args[1])
+            if err != nil || ok { return value, err }
+            return Expr{}, fmt.Errorf("index %d out of range for %s of length %d", args[1].Int, args[0].Type.Str(), args[0].length())
+        },
+    },
+    Function{
+        Id: "contains?",
+        Types: []FunctionType{
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityRegular},
+        },
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            _, ok, err := args[0].index(args[1])
+            return Expr{Type: ExprBool, Bool: ok}, err
+        },
+    },
+    Function{
+        Id: "assoc",
+        Types: []FunctionType{
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityRange, From: 2, To: QUANTITY_UNBOUNDED},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (res Expr, err error) {
+            if len(args) % 2 != 1 {
+                return res, fmt.Errorf("assoc expects key/value pairs")
+            }
+            res = args[0]
+            for i := 1; i < len(args); i += 2 {
+                res, err = res.assoc(args[i], args[i + 1])
+                if err != nil { return }
+            }
+            return
+        },
+    },
+    Function{
+        Id: "dissoc",
+        Types: []FunctionType{
+            FunctionType{Type: ExprMap, QType: QuantityRegular},
+            FunctionType{Type: ExprAny, QType: QuantityAny},
+        },
+        Returns: ExprMap,
+        Impl: func(args []Expr) (Expr, error) {
+            m := args[0].Map.Copy()
+            for _, key := range args[1:] {
+                m.Delete(key)
+            }
+            return mapExpr(m), nil
+        },
+    },
+    Function{
+        Id: "keys",
+        Types: []FunctionType{
+            FunctionType{Type: ExprMap, QType: QuantityRegular},
+        },
+        Returns: ExprVector,
+        Impl: func(args []Expr) (Expr, error) {
+            return vectorExpr(append([]Expr(nil), args[0].Map.Keys...)), nil
+        },
+    },
+    Function{
+        Id: "values",
+        Types: []FunctionType{
+            FunctionType{Type: ExprMap, QType: QuantityRegular},
+        },
+        Returns: ExprVector,
+        Impl: func(args []Expr) (Expr, error) {
+            return vectorExpr(append([]Expr(nil), args[0].Map.Values...)), nil
+        },
+    },
+    Function{
+        Id: "apply",
+        Types: []FunctionType{
+            FunctionType{Type: ExprFunc, QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityAny},
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (Expr, error) {
+            items, _ := args[len(args) - 1].items()
+            callArgs := append(append([]Expr(nil), args[1:len(args) - 1]...), items...)
+            return callFunction(args[0].Range(), args[0], callArgs)
+        },
+    },
+    Function{
+        Id: "map",
+        Types: FUNC_AND_COLL,
+        Returns: ExprAny,
+        Impl: func(args []Expr) (res Expr, err error) {
+            items, _ := args[1].items()
+            mapped := make([]Expr, len(items))
+            for i := range items {
+                mapped[i], err = callFunction(args[0].Range(), args[0], items[i:i+1])
+                if err != nil { return }
+            }
+            return seqExpr(args[1].seqType(), mapped), nil
+        },
+    },
+    Function{
+        Id: "filter",
+        Types: FUNC_AND_COLL,
+        Returns: ExprAny,
+        Impl: func(args []Expr) (res Expr, err error) {
+            items, _ := args[1].items()
+            var kept []Expr
+            for i := range items {
+                res, err = callFunction(args[0].Range(), args[0], items[i:i+1])
+                if err != nil { return }
+                if res.Truthy() {
+                    kept = append(kept, items[i])
+                }
+            }
+            return seqExpr(args[1].seqType(), kept), nil
+        },
+    },
+    Function{
+        Id: "reduce",
+        Types: []FunctionType{
+            FunctionType{Type: ExprFunc, QType: QuantityRegular},
+            FunctionType{Type: ExprAny,  QType: QuantityRange, From: 0, To: 1},
+            FunctionType{Type: ExprColl, QType: QuantityRegular},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (res Expr, err error) {
+            items, _ := args[len(args) - 1].items()
+            if len(args) == 3 {
+                res = args[1]
+            } else if len(items) == 0 {
+                return callFunction(args[0].Range(), args[0], nil)
+            } else {
+                res, items = items[0], items[1:]
+            }
+            for i := range items {
+                res, err = callFunction(args[0].Range(), args[0], []Expr{res, items[i]})
+                if err != nil { return }
+            }
+            return
+        },
+    },
+    Function{
+        Id: "for-each",
+        Types: FUNC_AND_COLL,
+        Returns: ExprNil,
+        Impl: func(args []Expr) (res Expr, err error) {
+            items, _ := args[1].items()
+            for i := range items {
+                _, err = callFunction(args[0].Range(), args[0], items[i:i+1])
+                if err != nil { return }
+            }
+            return Expr{Type: ExprNil}, nil
+        },
+    },
+    Function{
+        Id: "not",
+        Types: []FunctionType{
+            FunctionType{Type: ExprAny, QType: QuantityRegular},
+        },
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            return boolExpr(!args[0].Truthy()), nil
+        },
+    },
+    Function{
+        Id: "=",
+        Types: ANY_SOME,
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            for i := 1; i < len(args); i++ {
+                if !args[i - 1].Equal(&args[i]) { return boolExpr(false), nil }
+            }
+            return boolExpr(true), nil
+        },
+    },
+    Function{
+        Id: "!=",
+        Types: ANY_SOME,
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            for i := 1; i < len(args); i++ {
+                if !args[i - 1].Equal(&args[i]) { return boolExpr(true), nil }
+            }
+            return boolExpr(false), nil
+        },
+    },
+    compareFunction("<",  func(cmp int) bool { return cmp <  0 }),
+    compareFunction("<=", func(cmp int) bool { return cmp <= 0 }),
+    compareFunction(">",  func(cmp int) bool { return cmp >  0 }),
+    compareFunction(">=", func(cmp int) bool { return cmp >= 0 }),
+    Function{
+        Id: "error",
+        Types: []FunctionType{
+            FunctionType{Type: ExprAny, QType: QuantityRegular},
+        },
+        Returns: ExprAny,
+        Impl: func(args []Expr) (Expr, error) {
+            switch (args[0].Type) {
+            case ExprError: return Expr{}, args[0].Err
+            case ExprStr:   return Expr{}, errors.New(args[0].Str)
+            }
+            return Expr{}, errors.New(args[0].String())
+        },
+    },
+    Function{
+        Id: "error-message",
+        Types: []FunctionType{
+            FunctionType{Type: ExprError, QType: QuantityRegular},
+        },
+        Returns: ExprStr,
+        Impl: func(args []Expr) (Expr, error) {
+            msg := args[0].Err.Error()
+            if err, ok := args[0].Err.(*LangError); ok { msg = err.Msg }
+            return Expr{Type: ExprStr, Str: msg}, nil
+        },
+    },
+    Function{
+        Id: "error?",
+        Types: []FunctionType{
+            FunctionType{Type: ExprAny, QType: QuantityRegular},
+        },
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            return boolExpr(args[0].Type == ExprError), nil
+        },
+    },
+    Function{
+        Id: "gensym",
+        Types: []FunctionType{
+            FunctionType{Type: ExprStr, QType: QuantityRange, From: 0, To: 1},
+        },
+        Returns: ExprId,
+        Impl: func(args []Expr) (Expr, error) {
+            prefix := "G"
+            if len(args) == 1 { prefix = args[0].Str }
+            n := atomic.AddUint64(&gensymCounter, 1)
+            return Expr{Type: ExprId, Id: fmt.Sprintf("%s__%d", prefix, n)}, nil
+        },
+    },
+}
+
+var gensymCounter uint64
+
+// concatFunction builds the overload of + joining strings, vectors or
+// lists of one type.
+func concatFunction(Type ExprType) Function {
+    return Function{
+        Id: "+",
+        Types: []FunctionType{
+            FunctionType{Type: Type, QType: QuantityRange, From: 1, To: QUANTITY_UNBOUNDED},
+        },
+        Returns: Type,
+        Impl: func(args []Expr) (Expr, error) {
+            if Type == ExprStr {
+                var sb strings.Builder
+                for _, arg := range args {
+                    sb.WriteString(arg.Str)
+                }
+                return Expr{Type: ExprStr, Str: sb.String()}, nil
+            }
+            var items []Expr
+            for _, arg := range args {
+                items = append(items, arg.Args...)
+            }
+            return seqExpr(Type, items), nil
+        },
+    }
+}
+// compareFunction builds a chained numeric comparison, (< a b c) holds
+// when every adjacent pair does.
+func compareFunction(id string, holds func(cmp int) bool) Function {
+    return Function{
+        Id: id,
+        Types: NUMBERS_SOME,
+        Returns: ExprBool,
+        Impl: func(args []Expr) (Expr, error) {
+            for i := 1; i < len(args); i++ {
+                cmp, ok := numCompare(args[i - 1], args[i])
+                if !ok || !holds(cmp) { return boolExpr(false), nil }
+            }
+            return boolExpr(true), nil
+        },
+    }
+}
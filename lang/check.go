@@ -0,0 +1,342 @@
+package lang
+
+import (
+    "strings"
+)
+
+// Check looks for calls in forms whose arguments can't fit the
+// signature of the function called, without evaluating anything. Types
+// are inferred from literals, function results and bindings, whatever
+// can't be inferred is any and fits everywhere, so is a name the program
+// defines before its definition is seen, or that a macro call or a
+// module could bind. Only calls that would fail whatever happens at run
+// time are reported, each as a *LangError, calls in the body of a try
+// are left to it.
+func Check(env *Env, forms []Expr) []error {
+    c := checker{
+        env:     env,
+        scopes:  []map[string]binding{{}},
+        mutated: map[string]bool{},
+        macros:  map[string]bool{},
+        defined: map[string]bool{},
+        modules: map[string]bool{},
+    }
+    defined := map[string]int{}
+    for i := range forms {
+        c.prescan(&forms[i], defined)
+    }
+    for i := range forms {
+        c.rebound(&forms[i])
+    }
+    for name, n := range defined {
+        if n > 1 { c.mutated[name] = true }
+    }
+    for i := range forms {
+        c.infer(&forms[i])
+    }
+    return c.errs
+}
+
+type checker struct {
+    env     *Env
+    scopes  []map[string]binding
+    // names that are set!, defined more than once or passed to a macro,
+    // their type isn't known from the binding alone
+    mutated map[string]bool
+    // names defined with defmacro, their arguments are not code
+    macros  map[string]bool
+    // names bound by define or defn anywhere, they may shadow a function
+    // of the registry before their binding is seen
+    defined map[string]bool
+    // prefixes of the names bound by require and import, an empty one
+    // for modules bound without a prefix
+    modules map[string]bool
+    // forms being checked, deeper ones than Eval could run are skipped
+    depth   int
+    errs    []error
+}
+// binding is what the checker knows about a name, Func is the
+// signature of functions whose definition it has seen.
+type binding struct {
+    Type ExprType
+    Func *Function
+}
+
+func (c *checker) prescan(expr *Expr, defined map[string]int) {
+    if expr.Type != ExprList && expr.Type != ExprVector && expr.Type != ExprMap { return }
+    if c.depth >= c.env.State.MaxDepth { return }
+    c.depth += 1
+    defer func() { c.depth -= 1 }()
+    if expr.Type == ExprList && len(expr.Args) >= 2 && expr.Args[0].Type == ExprId && expr.Args[1].Type == ExprId {
+        name := expr.Args[1].Id
+        switch (expr.Args[0].Id) {
+        case "set!":     c.mutated[name] = true
+        case "define":   fallthrough
+        case "defn":
+            defined[name] += 1
+            c.defined[name] = true
+        case "defmacro": c.macros[name] = true
+        }
+    }
+    if expr.Type == ExprList && len(expr.Args) >= 2 && expr.Args[0].Type == ExprId {
+        switch (expr.Args[0].Id) {
+        case "require": fallthrough
+        case "import":
+            prefix := ""
+            if expr.Args[0].Id == "import" && expr.Args[1].Type == ExprId { prefix = expr.Args[1].Id + "/" }
+            if len(expr.Args) == 4 && expr.Args[3].Type == ExprId { prefix = expr.Args[3].Id + "/" }
+            c.modules[prefix] = true
+        }
+    }
+    for i := range expr.Args {
+        c.prescan(&expr.Args[i], defined)
+    }
+}
+
+// rebound marks the identifiers passed to macros as mutated, their
+// expansion may bind them to anything.
+func (c *checker) rebound(expr *Expr) {
+    if expr.Type != ExprList && expr.Type != ExprVector && expr.Type != ExprMap { return }
+    if c.depth >= c.env.State.MaxDepth { return }
+    c.depth += 1
+    defer func() { c.depth -= 1 }()
+    if expr.Type == ExprList && len(expr.Args) > 0 && expr.Args[0].Type == ExprId && c.macros[expr.Args[0].Id] {
+        for _, arg := range expr.Args[1:] {
+            if arg.Type == ExprId { c.mutated[arg.Id] = true }
+        }
+    }
+    for i := range expr.Args {
+        c.rebound(&expr.Args[i])
+    }
+}
+// module reports whether require or import may bind name.
+func (c *checker) module(name string) bool {
+    for prefix := range c.modules {
+        if strings.HasPrefix(name, prefix) { return true }
+    }
+    return false
+}
+
+func (c *checker) push() {
+    c.scopes = append(c.scopes, map[string]binding{})
+}
+func (c *checker) pop() {
+    c.scopes = c.scopes[:len(c.scopes) - 1]
+}
+func (c *checker) bind(name string, Type ExprType, fn *Function) {
+    if c.mutated[name] { Type, fn = ExprAny, nil }
+    c.scopes[len(c.scopes) - 1][name] = binding{Type: Type, Func: fn}
+}
+func (c *checker) local(name string) (b binding, ok bool) {
+    for i := len(c.scopes) - 1; i >= 0; i-- {
+        if b, ok = c.scopes[i][name]; ok { return }
+    }
+    return
+}
+// closure checks the lambda, defn or defmacro expr with its parameter
+// list at expr.Args[params] and returns its signature, nil if it is
+// malformed.
+func (c *checker) closure(expr *Expr, params int, id string) *Function {
+    closure, err := parseClosure(expr, params, nil)
+    if err != nil {
+        c.errs = append(c.errs, err)
+        return nil
+    }
+    sig := &Function{Id: id, Types: closure.Types, Returns: closure.Returns}
+    if expr.Args[0].Id == "defn" { c.bind(id, ExprFunc, sig) }
+    c.push()
+    defer c.pop()
+    for i, param := range closure.Params {
+        c.bind(param, closure.Types[i].Type, nil)
+    }
+    if closure.Rest != "" { c.bind(closure.Rest, ExprList, nil) }
+    Type := c.body(closure.Body)
+    if len(closure.Body) > 0 && !mayAccept(closure.Returns, Type) {
+        last := &closure.Body[len(closure.Body) - 1]
+        c.errs = append(c.errs, errorAt(last.Range(), "'%s' must return %s, got %s", id, closure.Returns.Str(), Type.Str()))
+    }
+    return sig
+}
+// value checks the value bound to name and returns its type, and its
+// signature if it is a lambda.
+func (c *checker) value(name string, expr *Expr) (ExprType, *Function) {
+    if expr.Type == ExprList && len(expr.Args) >= 2 && expr.Args[0].Type == ExprId && expr.Args[0].Id == "lambda" {
+        return ExprFunc, c.closure(expr, 1, name)
+    }
+    return c.infer(expr), nil
+}
+
+// infer checks expr and returns the type it evaluates to.
+func (c *checker) infer(expr *Expr) ExprType {
+    if c.depth >= c.env.State.MaxDepth { return ExprAny }
+    c.depth += 1
+    defer func() { c.depth -= 1 }()
+    switch (expr.Type) {
+    case ExprId:
+        if b, ok := c.local(expr.Id); ok { return b.Type }
+        if c.defined[expr.Id] || c.module(expr.Id) { return ExprAny }
+        if value, ok := c.env.Lookup(expr.Id); ok && !c.mutated[expr.Id] { return value.Type }
+        return ExprAny
+    case ExprList:
+        return c.inferList(expr)
+    case ExprVector: fallthrough
+    case ExprMap:
+        c.body(expr.Args)
+        return expr.Type
+    }
+    return expr.Type
+}
+// body checks exprs and returns the type of the last one.
+func (c *checker) body(exprs []Expr) ExprType {
+    Type := ExprNil
+    for i := range exprs {
+        Type = c.infer(&exprs[i])
+    }
+    return Type
+}
+func (c *checker) inferList(expr *Expr) ExprType {
+    if len(expr.Args) == 0 { return ExprNil }
+    head := &expr.Args[0]
+    if head.Type != ExprId {
+        c.body(expr.Args)
+        return ExprAny
+    }
+    if _, ok := SPECIAL_FORMS[head.Id]; ok { return c.inferForm(expr) }
+    if b, ok := c.local(head.Id); ok {
+        if b.Func != nil { return c.call(expr, b.Func) }
+        c.body(expr.Args[1:])
+        return ExprAny
+    }
+    if c.defined[head.Id] || c.module(head.Id) {
+        c.body(expr.Args[1:])
+        return ExprAny
+    }
+    value, ok := c.env.Lookup(head.Id)
+    // unknown heads may be macros of modules required on the way
+    if c.macros[head.Id] || !ok { return ExprAny }
+    if value.Type != ExprFunc || value.Func.Macro || c.mutated[head.Id] {
+        c.body(expr.Args[1:])
+        return ExprAny
+    }
+    return c.call(expr, value.Func)
+}
+// mayAccept reports whether a slot could take an argument of an
+// inferred type, classes and any fit whenever some value could.
+func mayAccept(slot, arg ExprType) bool {
+    return arg == ExprAny || slot.Accepts(arg) || arg.within(slot) || slot.within(arg)
+}
+// call checks the arguments of a call of fn and returns its result.
+func (c *checker) call(expr *Expr, fn *Function) ExprType {
+    args := make([]Expr, len(expr.Args) - 1)
+    for i := range args {
+        arg := &expr.Args[i + 1]
+        args[i] = Expr{Type: c.infer(arg), Loc: arg.Loc, End: arg.End}
+    }
+    var fits []*Function
+    var firstErr error
+    for _, overload := range fn.overloads() {
+        _, err := overload.assign(expr.Range(), args, mayAccept)
+        if err == nil {
+            fits = append(fits, overload)
+        } else if firstErr == nil {
+            firstErr = err
+        }
+    }
+    if len(fits) == 0 {
+        if fn.Overloads != nil {
+            types := make([]ExprType, len(args))
+            for i := range args {
+                types[i] = args[i].Type
+            }
+            firstErr = errorAt(expr.Range(), "no overload of '%s' takes (%s), candidates: %s", fn.Id, joinTypes(types), fn.candidates())
+        }
+        c.errs = append(c.errs, firstErr)
+        return ExprAny
+    }
+    Type := fits[0].Returns
+    for _, overload := range fits[1:] {
+        if overload.Returns != Type { return ExprAny }
+    }
+    return Type
+}
+// inferForm checks the parts of a special form that are code, like
+// expandForm, and returns the type of its value.
+func (c *checker) inferForm(expr *Expr) ExprType {
+    args := expr.Args
+    switch (args[0].Id) {
+    case "quote":
+        if len(args) != 2 { break }
+        return args[1].Type
+    case "quasiquote":
+        if len(args) != 2 { break }
+        if t := args[1].Type; t == ExprList || t == ExprVector || t == ExprMap { return t }
+        return ExprAny
+    case "require": fallthrough
+    case "import":  fallthrough
+    case "export":
+        return ExprNil
+    case "unquote": fallthrough
+    case "unquote-splicing":
+        return ExprAny
+    case "define":
+        if len(args) != 3 || args[1].Type != ExprId { break }
+        Type, sig := c.value(args[1].Id, &args[2])
+        c.bind(args[1].Id, Type, sig)
+        return Type
+    case "set!":
+        if len(args) != 3 { break }
+        return c.infer(&args[2])
+    case "let":
+        if len(args) < 2 || args[1].Type != ExprList { break }
+        bindings := make([]binding, len(args[1].Args))
+        for i := range args[1].Args {
+            binding := &args[1].Args[i]
+            if binding.Type != ExprList || len(binding.Args) != 2 || binding.Args[0].Type != ExprId { continue }
+            bindings[i].Type, bindings[i].Func = c.value(binding.Args[0].Id, &binding.Args[1])
+        }
+        c.push()
+        defer c.pop()
+        for i := range args[1].Args {
+            binding := &args[1].Args[i]
+            if binding.Type != ExprList || len(binding.Args) != 2 || binding.Args[0].Type != ExprId { continue }
+            c.bind(binding.Args[0].Id, bindings[i].Type, bindings[i].Func)
+        }
+        return c.body(args[2:])
+    case "lambda":
+        if len(args) < 2 { break }
+        c.closure(expr, 1, "lambda")
+        return ExprFunc
+    case "defn":     fallthrough
+    case "defmacro":
+        if len(args) < 3 || args[1].Type != ExprId { break }
+        c.closure(expr, 2, args[1].Id)
+        return ExprFunc
+    case "if":
+        if len(args) != 3 && len(args) != 4 { break }
+        c.infer(&args[1])
+        then, otherwise := c.infer(&args[2]), ExprNil
+        if len(args) == 4 { otherwise = c.infer(&args[3]) }
+        if then == otherwise { return then }
+        return ExprAny
+    case "cond":
+        for i := 1; i < len(args); i++ {
+            if args[i].Type == ExprList { c.body(args[i].Args) }
+        }
+        return ExprAny
+    case "try":
+        last := &args[len(args) - 1]
+        if len(args) < 2 || !last.isCatch() { break }
+        // what fails in the body is caught, only its bindings matter
+        n := len(c.errs)
+        c.body(args[1:len(args) - 1])
+        c.errs = c.errs[:n]
+        c.push()
+        defer c.pop()
+        c.bind(last.Args[1].Id, ExprError, nil)
+        c.body(last.Args[2:])
+        return ExprAny
+    }
+    // forms with an unexpected shape are left for Eval to report
+    c.body(args[1:])
+    return ExprAny
+}
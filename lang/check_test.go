@@ -0,0 +1,30 @@
+package lang
+
+import "testing"
+
+func TestCheckAccepts(t *testing.T) {
+    // names the program defines later are any until their binding is
+    // seen, names it set! or passes to macros are any everywhere, calls
+    // in a try may fail
+    checkEval(t, []evalTest{
+        {"(defn bump [] (+ max 1)) (define max 5) (bump)",                          "int 6"},
+        {"(defn total [] (reduce + 0 values)) (define values [1 2 3]) (total)",     "int 6"},
+        {"(defn f [x :int] x) (defn g [] (f \"a\")) (defn f [s :str] s) (g)",       "str \"a\""},
+        {"(define k 1) (set! k \"a\") (+ k \"b\")",                                 "str \"ab\""},
+        {"(defmacro setv [n v] `(set! ,n ,v)) (define x \"a\") (setv x 1) (+ x 1)", "int 2"},
+        {"(defmacro defvar [n v] `(define ,n ,v)) (defvar max 1) (+ max 1)",        "int 2"},
+        {"(try (+ 1 \"a\") (catch e 1))",                                           "int 1"},
+    })
+}
+
+func TestCheckRejects(t *testing.T) {
+    // calls that can't succeed are reported before anything runs, even
+    // if they are never reached
+    checkEval(t, []evalTest{
+        {"(+ 1 \"a\")",                   "error no overload of '+' takes (int str)"},
+        {"(defn never [] (+ 1 \"a\")) 1", "error no overload of '+' takes (int str)"},
+        {"(define k 1) (+ k \"a\")",      "error no overload of '+' takes (int str)"},
+        {"(defn h [x :int] x) (h \"a\")", "error argument 1 of 'h' must be int, got str"},
+        {"(defn bad [a :int] :str a) 1",  "error 'bad' must return str, got int"},
+    })
+}
@@ -0,0 +1,204 @@
+package lang
+
+import (
+    "fmt"
+)
+
+// Map is an insertion ordered hash map. Maps are treated as immutable
+// values, assoc and dissoc work on a Copy.
+type Map struct {
+    Keys   []Expr
+    Values []Expr
+    index  map[string]int
+}
+func NewMap() *Map {
+    return &Map{index: map[string]int{}}
+}
+// hashKey identifies a value used as a map key. Functions, maps and
+// errors can't be keys.
+func (expr *Expr) hashKey() (key string, ok bool) {
+    switch (expr.Type) {
+    case ExprFunc: fallthrough
+    case ExprMap:  fallthrough
+    case ExprError:
+        return "", false
+    case ExprList: fallthrough
+    case ExprVector:
+        for i := range expr.Args {
+            if _, ok = expr.Args[i].hashKey(); !ok { return }
+        }
+    }
+    return expr.Type.Str() + ":" + expr.String(), true
+}
+func unhashableError(key *Expr) error {
+    return fmt.Errorf("%s can't be used as a map key", key.Type.Str())
+}
+func (m *Map) Len() int {
+    return len(m.Keys)
+}
+func (m *Map) Get(key Expr) (value Expr, ok bool) {
+    hash, ok := key.hashKey()
+    if !ok { return }
+    i, ok := m.index[hash]
+    if !ok { return }
+    return m.Values[i], true
+}
+func (m *Map) Set(key, value Expr) error {
+    hash, ok := key.hashKey()
+    if !ok { return unhashableError(&key) }
+    if i, ok := m.index[hash]; ok {
+        m.Values[i] = value
+        return nil
+    }
+    m.index[hash] = len(m.Keys)
+    m.Keys   = append(m.Keys,   key)
+    m.Values = append(m.Values, value)
+    return nil
+}
+func (m *Map) Delete(key Expr) {
+    hash, ok := key.hashKey()
+    if !ok { return }
+    i, ok := m.index[hash]
+    if !ok { return }
+    m.Keys   = append(m.Keys[:i:i],   m.Keys[i+1:]...)
+    m.Values = append(m.Values[:i:i], m.Values[i+1:]...)
+    delete(m.index, hash)
+    for hash, j := range m.index {
+        if j > i { m.index[hash] = j - 1 }
+    }
+}
+func (m *Map) Copy() *Map {
+    res := &Map{
+        Keys:   append([]Expr(nil), m.Keys...),
+        Values: append([]Expr(nil), m.Values...),
+        index:  make(map[string]int, len(m.index)),
+    }
+    for hash, i := range m.index {
+        res.index[hash] = i
+    }
+    return res
+}
+
+func mapExpr(m *Map) Expr {
+    return Expr{Type: ExprMap, Map: m}
+}
+func vectorExpr(items []Expr) Expr {
+    return Expr{Type: ExprVector, Args: items}
+}
+func seqExpr(Type ExprType, items []Expr) Expr {
+    return Expr{Type: Type, Args: items}
+}
+
+// items returns the elements of a collection in iteration order:
+// maps yield [key value] vectors, strings yield chars.
+func (expr *Expr) items() (items []Expr, ok bool) {
+    switch (expr.Type) {
+    case ExprList: fallthrough
+    case ExprVector:
+        return expr.Args, true
+    case ExprMap:
+        for i := range expr.Map.Keys {
+            items = append(items, vectorExpr([]Expr{expr.Map.Keys[i], expr.Map.Values[i]}))
+        }
+        return items, true
+    case ExprStr:
+        for _, ch := range expr.Str {
+            items = append(items, Expr{Type: ExprChar, Char: ch})
+        }
+        return items, true
+    case ExprNil:
+        return nil, true
+    }
+    return nil, false
+}
+// seqType is the kind of sequence map and filter build from expr.
+func (expr *Expr) seqType() ExprType {
+    if expr.Type == ExprList { return ExprList }
+    return ExprVector
+}
+func (expr *Expr) length() int {
+    switch (expr.Type) {
+    case ExprMap: return expr.Map.Len()
+    case ExprStr: return len([]rune(expr.Str))
+    }
+    return len(expr.Args)
+}
+// index looks up key in a collection: maps by key, everything else by
+// position.
+func (expr *Expr) index(key Expr) (value Expr, ok bool, err error) {
+    if expr.Type == ExprMap {
+        if _, ok = key.hashKey(); !ok { return value, false, unhashableError(&key) }
+        value, ok = expr.Map.Get(key)
+        return
+    }
+    if key.Type != ExprInt {
+        return value, false, fmt.Errorf("%s index must be int, got %s", expr.Type.Str(), key.Type.Str())
+    }
+    if expr.Type == ExprStr {
+        chars := []rune(expr.Str)
+        if key.Int < 0 || key.Int >= int64(len(chars)) { return }
+        return Expr{Type: ExprChar, Char: chars[key.Int]}, true, nil
+    }
+    if key.Int < 0 || key.Int >= int64(len(expr.Args)) { return }
+    return expr.Args[key.Int], true, nil
+}
+// assoc returns a copy of a map or vector with key set to value.
+// Vectors can be extended by one element at their end.
+func (expr *Expr) assoc(key, value Expr) (res Expr, err error) {
+    switch (expr.Type) {
+    case ExprMap:
+        m := expr.Map.Copy()
+        err = m.Set(key, value)
+        return mapExpr(m), err
+    case ExprVector:
+        if key.Type != ExprInt {
+            return res, fmt.Errorf("vector index must be int, got %s", key.Type.Str())
+        }
+        if key.Int < 0 || key.Int > int64(len(expr.Args)) {
+            return res, fmt.Errorf("index %d out of range for vector of length %d", key.Int, len(expr.Args))
+        }
+        items := append([]Expr(nil), expr.Args...)
+        if key.Int == int64(len(items)) {
+            items = append(items, value)
+        } else {
+            items[key.Int] = value
+        }
+        return vectorExpr(items), nil
+    }
+    return res, fmt.Errorf("can't assoc into %s", expr.Type.Str())
+}
+
+// Equal compares values structurally, numbers by value regardless of
+// their kind and functions by identity.
+func (expr *Expr) Equal(other *Expr) bool {
+    if expr.IsNumber() && other.IsNumber() {
+        cmp, ok := numCompare(*expr, *other)
+        return ok && cmp == 0
+    }
+    if expr.Type != other.Type { return false }
+    switch (expr.Type) {
+    case ExprNil:     return true
+    case ExprBool:    return expr.Bool == other.Bool
+    case ExprChar:    return expr.Char == other.Char
+    case ExprStr:     return expr.Str  == other.Str
+    case ExprId:      fallthrough
+    case ExprKeyword: return expr.Id   == other.Id
+    case ExprFunc:    return expr.Func == other.Func
+    case ExprError:   return expr.Err  == other.Err
+    case ExprList:    fallthrough
+    case ExprVector:
+        if len(expr.Args) != len(other.Args) { return false }
+        for i := range expr.Args {
+            if !expr.Args[i].Equal(&other.Args[i]) { return false }
+        }
+        return true
+    case ExprMap:
+        if expr.Map.Len() != other.Map.Len() { return false }
+        for i, key := range expr.Map.Keys {
+            value, ok := other.Map.Get(key)
+            if !ok || !expr.Map.Values[i].Equal(&value) { return false }
+        }
+        return true
+    }
+    return false
+}
@@ -0,0 +1,104 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "fmt"
+    "strings"
+)
+
+type Severity uint8
+const (
+    SeverityError Severity = iota
+    SeverityWarning
+    SeverityNote
+)
+func (s Severity) Str() string {
+    switch (s) {
+    case SeverityError:   return "error"
+    case SeverityWarning: return "warning"
+    case SeverityNote:    return "note"
+    }
+    log.Unreachable("unknown Severity")
+    return ""
+}
+
+// Range is the span of source from Start up to, not including, End.
+type Range struct {
+    Start Location
+    End   Location
+}
+
+// Label points at another part of the sources a Diagnostic is about,
+// e.g. where an unmatched paren was opened.
+type Label struct {
+    Range   Range
+    Message string
+}
+
+// Diagnostic is a problem found in the sources, reported without
+// stopping whatever found it.
+type Diagnostic struct {
+    Severity Severity
+    Range    Range
+    Message  string
+    Labels   []Label
+}
+func (d Diagnostic) Error() string {
+    return fmt.Sprintf("%s: %s", d.Range.Start.Loc(), d.Message)
+}
+
+// PrintError prints err to stderr, each of the errors it joins on its
+// own. Diagnostics and errors with a location are rendered with the
+// lines of the sources they point at, see log.PrintDiagnostic.
+func (l *Lexer) PrintError(err error) {
+    if joined, ok := err.(interface{ Unwrap() []error }); ok {
+        for _, err := range joined.Unwrap() {
+            l.PrintError(err)
+        }
+        return
+    }
+    var diag Diagnostic
+    switch e := err.(type) {
+    case Diagnostic:
+        diag = e
+    case *LangError:
+        diag = Diagnostic{Severity: SeverityError, Range: Range{Start: e.Loc, End: e.End}, Message: e.Msg}
+    default:
+        log.Errorf("%s", err.Error())
+        return
+    }
+    log.PrintDiagnostic(l.render(diag))
+}
+// lines splits the source loc is in into lines, nil if it isn't one of
+// the sources of l, e.g. a module read by another lexer.
+func (l *Lexer) lines(loc Location) []string {
+    if loc.SourceIndex < 0 || loc.SourceIndex >= len(l.Sources) { return nil }
+    source := l.Sources[loc.SourceIndex]
+    if source.Name != loc.Source { return nil }
+    return strings.Split(string(source.Chars), "\n")
+}
+func (r Range) span(message string) log.Span {
+    s := log.Span{Line: r.Start.Line, Column: r.Start.Column, EndLine: r.End.Line, EndColumn: r.End.Column, Message: message}
+    // the end of the last token of a source is the start of the next one
+    if r.End.SourceIndex != r.Start.SourceIndex { s.EndLine, s.EndColumn = 0, 0 }
+    return s
+}
+func (l *Lexer) render(d Diagnostic) log.Diagnostic {
+    start := d.Range.Start
+    res := log.Diagnostic{
+        Severity: d.Severity.Str(),
+        Message:  d.Message,
+        Source:   start.Source,
+        Lines:    l.lines(start),
+        Primary:  d.Range.span(""),
+    }
+    for _, label := range d.Labels {
+        at := label.Range.Start
+        if res.Lines != nil && at.SourceIndex == start.SourceIndex && at.Source == start.Source {
+            res.Labels = append(res.Labels, label.Range.span(label.Message))
+        } else {
+            res.Notes = append(res.Notes, fmt.Sprintf("%s at %s", label.Message, at.Loc()))
+        }
+    }
+    return res
+}
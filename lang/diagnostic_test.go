@@ -0,0 +1,88 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "errors"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// renderString renders the first problem found in src as it would be
+// printed, without colors.
+func renderString(src string) string {
+    l := LexerInit()
+    l.AddNamedExpr("test", src)
+    var diag Diagnostic
+    if _, diags := l.ParseAll(); diags != nil {
+        diag = diags[0]
+    } else if _, err := NewInterpreter().RunString("test", src); err != nil {
+        var e *LangError
+        errors.As(err, &e)
+        diag = Diagnostic{Severity: SeverityError, Range: Range{Start: e.Loc, End: e.End}, Message: e.Msg}
+    }
+    var out strings.Builder
+    log.FprintDiagnostic(&out, l.render(diag))
+    return ansiEscape.ReplaceAllString(strings.ReplaceAll(out.String(), "\r\n", "\n"), "")
+}
+
+func TestRender(t *testing.T) {
+    tests := []struct {
+        src, want string
+    }{
+        {"(define x (+ 1 2])", `
+error: expected ) to close ( from test:1:11, got ]
+ --> test:1:17
+  |
+1 | (define x (+ 1 2])
+  |                 ^
+  |           - ( opened here
+`},
+        {"(define f (lambda (x)\n  x\n(define y 2)", `
+error: unclosed (
+ --> test:1:1
+  |
+1 | (define f (lambda (x)
+  | ^
+...
+3 | (define y 2)
+  | - expected ) before this form
+`},
+        // spans are underlined up to their end, or the end of the line
+        {"(define name\n  (+ 1 \"a\"))", `
+error: no overload of '+' takes (int str), candidates: (+ number...), (+ str{1..}), (+ vector{1..}), (+ list{1..})
+ --> test:2:3
+  |
+2 |   (+ 1 "a"))
+  |   ^~~~~~~~~
+`},
+        {"(+ 1\n   \"a\")", `
+error: no overload of '+' takes (int str), candidates: (+ number...), (+ str{1..}), (+ vector{1..}), (+ list{1..})
+ --> test:1:1
+  |
+1 | (+ 1
+  | ^~~~
+`},
+        {"(a\t\"\\q\")", `
+error: q unknown escape character
+ --> test:1:5
+  |
+1 | (a    "\q")
+  |        ^~~
+`},
+        {"(defn h [x :int] x)\n(h \"abc\")", `
+error: argument 1 of 'h' must be int, got str
+ --> test:2:4
+  |
+2 | (h "abc")
+  |    ^~~~~
+`},
+    }
+    for _, test := range tests {
+        if got := renderString(test.src); got != test.want[1:] {
+            t.Errorf("%q: got\n%s\nwant\n%s", test.src, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,69 @@
+package lang
+
+// MAX_EVAL_DEPTH is the default limit of nested evaluation, past it
+// Eval fails with a stack overflow error instead of exhausting the Go
+// stack. Forms nested deeper are reported by the parser.
+var MAX_EVAL_DEPTH = 10000
+
+// EvalState is shared by all scopes created from the same root Env.
+type EvalState struct {
+    Depth    int
+    MaxDepth int
+    // functions the outermost scope falls back to
+    Funcs    *Registry
+    // loaded modules by absolute path, and the chain being loaded
+    Modules  map[string]*Module
+    Loading  []*Module
+    // modules are only loaded from under ModuleRoot, none are if it is
+    // empty, and only by the sources in Files, which were read from
+    // files
+    ModuleRoot string
+    Files      map[string]bool
+}
+
+// Env is a lexical scope. Lookups that miss fall back to Parent, the
+// outermost scope falls back to the functions of the Registry in State.
+type Env struct {
+    Vars   map[string]Expr
+    Parent *Env
+    State  *EvalState
+    // set on the top-level scope of a module
+    Module *Module
+}
+func NewEnv(parent *Env) *Env {
+    env := &Env{Vars: map[string]Expr{}, Parent: parent}
+    if parent != nil {
+        env.State = parent.State
+    } else {
+        env.State = &EvalState{
+            MaxDepth: MAX_EVAL_DEPTH,
+            Funcs:    DefaultRegistry(),
+            Modules:  map[string]*Module{},
+            Files:    map[string]bool{},
+        }
+    }
+    return env
+}
+func (env *Env) Lookup(id string) (value Expr, ok bool) {
+    funcs := env.State.Funcs
+    for ; env != nil; env = env.Parent {
+        if value, ok = env.Vars[id]; ok { return }
+    }
+    _func := funcs.Lookup(id)
+    if _func == nil { return }
+    return Expr{Type: ExprFunc, Func: _func}, true
+}
+// Define binds id in this scope, shadowing any outer binding.
+func (env *Env) Define(id string, value Expr) {
+    env.Vars[id] = value
+}
+// Set rebinds id in the nearest scope that defines it.
+func (env *Env) Set(id string, value Expr) bool {
+    for ; env != nil; env = env.Parent {
+        if _, ok := env.Vars[id]; ok {
+            env.Vars[id] = value
+            return true
+        }
+    }
+    return false
+}
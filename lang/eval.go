@@ -0,0 +1,293 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// LangError is an error raised while evaluating gosp code, try hands
+// it to the program as an ExprError value.
+type LangError struct {
+    Loc Location
+    // the end of the expression at Loc, zero if only Loc is known
+    End Location
+    Msg string
+}
+func (e *LangError) Error() string {
+    return e.Loc.Loc() + ": " + e.Msg
+}
+func errorAt(at Range, format string, args ...any) error {
+    return &LangError{Loc: at.Start, End: at.End, Msg: fmt.Sprintf(format, args...)}
+}
+// Range is the span of source expr was read from.
+func (expr *Expr) Range() Range {
+    return Range{Start: expr.Loc, End: expr.End}
+}
+
+// Eval evaluates expr to a value in env. Lists are special forms or
+// function calls, identifiers are looked up, vector and map literals
+// evaluate their items and everything else evaluates to itself.
+//
+// Calls of gosp functions and special forms in tail position don't
+// nest: they hand back the expression left to evaluate, which Eval
+// continues with in the same frame. Nested evaluation is limited to
+// the MaxDepth of env's EvalState. Declared result types of closures
+// called in tail position are checked once the final value is known.
+func (expr *Expr) Eval(env *Env) (res Expr, err error) {
+    state := env.State
+    if state.Depth >= state.MaxDepth {
+        return res, errorAt(expr.Range(), "stack overflow, evaluation depth exceeded %d", state.MaxDepth)
+    }
+    state.Depth += 1
+    defer func() { state.Depth -= 1 }()
+    // all of them check the same final value, innermost first
+    var checks []resultCheck
+    defer func() {
+        for i := len(checks) - 1; i >= 0 && err == nil; i-- {
+            err = checks[i].run(res)
+        }
+    }()
+    for {
+        var tailEnv *Env
+        var check *resultCheck
+        switch (expr.Type) {
+        case ExprId:
+            var ok bool
+            res, ok = env.Lookup(expr.Id)
+            if !ok {
+                err = errorAt(expr.Range(), "unbound identifier '%s'", expr.Id)
+                return
+            }
+            if res.Type == ExprFunc { res.Loc, res.End = expr.Loc, expr.End }
+            return
+        case ExprList:
+            res, tailEnv, check, err = expr.evalList(env)
+            if check != nil { checks = check.pend(checks) }
+        case ExprVector:
+            items := make([]Expr, len(expr.Args))
+            for i := range expr.Args {
+                items[i], err = expr.Args[i].Eval(env)
+                if err != nil { return }
+            }
+            res = vectorExpr(items)
+            res.Loc, res.End = expr.Loc, expr.End
+            return
+        case ExprMap:
+            if expr.Map != nil { return *expr, nil }
+            return expr.evalMapLiteral(env)
+        default:
+            return *expr, nil
+        }
+        if err != nil || tailEnv == nil { return }
+        next := res
+        expr, env = &next, tailEnv
+    }
+}
+func (expr *Expr) evalMapLiteral(env *Env) (res Expr, err error) {
+    m := NewMap()
+    for i := 0; i + 1 < len(expr.Args); i += 2 {
+        var key, value Expr
+        key, err = expr.Args[i].Eval(env)
+        if err != nil { return }
+        if _, ok := m.Get(key); ok {
+            err = errorAt(expr.Args[i].Range(), "duplicate key %s in map literal", key.String())
+            return
+        }
+        value, err = expr.Args[i + 1].Eval(env)
+        if err != nil { return }
+        if err = m.Set(key, value); err != nil {
+            err = errorAt(expr.Args[i].Range(), "%s", err.Error())
+            return
+        }
+    }
+    res = mapExpr(m)
+    res.Loc, res.End = expr.Loc, expr.End
+    return
+}
+// resultCheck is the declared result type of a closure called in tail
+// position, checked by Eval when the call's value is known.
+type resultCheck struct {
+    closure *Closure
+    id      string
+    at      Range
+}
+func (check *resultCheck) run(res Expr) error {
+    if err := check.closure.checkResult(check.id, res); err != nil {
+        return errorAt(check.at, "%s", err.Error())
+    }
+    return nil
+}
+// pend adds check to checks. A closure that is already pending, like
+// one calling itself in a loop, is only checked once, at its innermost
+// call.
+func (check *resultCheck) pend(checks []resultCheck) []resultCheck {
+    for i := range checks {
+        if checks[i].closure == check.closure {
+            checks[i].at = check.at
+            return checks
+        }
+    }
+    return append(checks, *check)
+}
+// evalList evaluates a special form or a call. When tailEnv is set, res
+// is the expression in tail position still to be evaluated in tailEnv,
+// and check the result type that value must have, if any.
+func (expr *Expr) evalList(env *Env) (res Expr, tailEnv *Env, check *resultCheck, err error) {
+    if len(expr.Args) == 0 {
+        return Expr{Type: ExprNil, Loc: expr.Loc, End: expr.End}, nil, nil, nil
+    }
+    head := &expr.Args[0]
+    if head.Type == ExprId {
+        if form, ok := SPECIAL_FORMS[head.Id]; ok {
+            res, tailEnv, err = form(expr, env)
+            return
+        }
+    }
+    var fn Expr
+    fn, err = head.Eval(env)
+    if err != nil { return }
+    if fn.Type != ExprFunc {
+        err = errorAt(head.Range(), "%s is not a function", fn.Type.Str())
+        return
+    }
+    if fn.Func.Macro {
+        // defined after the expansion pass ran over expr
+        res, err = fn.Func.expandMacro(expr)
+        if err == nil { res, err = res.Expand(env) }
+        return res, env, nil, err
+    }
+    args := make([]Expr, 0, len(expr.Args) - 1)
+    for i := 1; i < len(expr.Args); i++ {
+        var arg Expr
+        arg, err = expr.Args[i].Eval(env)
+        if err != nil { return }
+        args = append(args, arg)
+    }
+    if closure := fn.Func.Closure; closure != nil {
+        if err = fn.Func.matchArgs(expr.Range(), args); err != nil { return }
+        if closure.Returns != ExprAny {
+            check = &resultCheck{closure: closure, id: fn.Func.Id, at: expr.Range()}
+        }
+        res, tailEnv, err = evalBody(closure.Body, closure.bind(args))
+        return
+    }
+    res, err = callFunction(expr.Range(), fn, args)
+    return
+}
+// callFunction applies an evaluated function value to evaluated
+// arguments, at is the call site errors are reported at.
+func callFunction(at Range, fn Expr, args []Expr) (res Expr, err error) {
+    if fn.Type != ExprFunc {
+        err = errorAt(at, "%s is not a function", fn.Type.Str())
+        return
+    }
+    if fn.Func.Macro {
+        err = errorAt(at, "macro '%s' can't be applied as a function", fn.Func.Id)
+        return
+    }
+    _func, err := fn.Func.resolve(at, args)
+    if err != nil { return }
+    res, err = _func.Impl(args)
+    if err != nil {
+        // errors of nested evaluation already carry their location
+        if _, nested := err.(*LangError); !nested {
+            err = errorAt(at, "%s", err.Error())
+        }
+        return
+    }
+    res.Loc, res.End = at.Start, at.End
+    return
+}
+
+// Truthy reports whether expr counts as true in a condition: everything
+// except nil and #f does.
+func (expr *Expr) Truthy() bool {
+    switch (expr.Type) {
+    case ExprNil:  return false
+    case ExprBool: return expr.Bool
+    }
+    return true
+}
+
+// String prints expr the way it would be written in source.
+func (expr *Expr) String() string {
+    switch (expr.Type) {
+    case ExprFunc:
+        if expr.Func.Macro { return "#<macro " + expr.Func.Id + ">" }
+        return "#<function " + expr.Func.Id + ">"
+    case ExprId:     return expr.Id
+    case ExprStr:    return quoteStr(expr.Str)
+    case ExprInt:    return strconv.FormatInt(expr.Int, 10)
+    case ExprDouble: return formatDouble(expr.Double)
+    case ExprBigInt: return expr.Big.String()
+    case ExprRatio:  return expr.Rat.RatString()
+    case ExprNil:    return "nil"
+    case ExprKeyword: return ":" + expr.Id
+    case ExprChar:   return FormatChar(expr.Char)
+    case ExprBool:
+        if expr.Bool { return "#t" }
+        return "#f"
+    case ExprError:  return "#<error " + quoteStr(expr.Err.Error()) + ">"
+    case ExprList:   return "(" + joinExprs(expr.Args) + ")"
+    case ExprVector: return "[" + joinExprs(expr.Args) + "]"
+    case ExprMap:
+        if expr.Map == nil { return "{" + joinExprs(expr.Args) + "}" }
+        items := make([]string, 0, 2*expr.Map.Len())
+        for i := range expr.Map.Keys {
+            items = append(items, expr.Map.Keys[i].String(), expr.Map.Values[i].String())
+        }
+        return "{" + strings.Join(items, " ") + "}"
+    }
+    log.Unreachable("unknown ExprType")
+    return ""
+}
+func joinExprs(exprs []Expr) string {
+    items := make([]string, len(exprs))
+    for i := range exprs {
+        items[i] = exprs[i].String()
+    }
+    return strings.Join(items, " ")
+}
+// quoteStr writes str as a string literal the lexer reads back.
+func quoteStr(str string) string {
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for _, ch := range str {
+        switch {
+        case ch == '"':  sb.WriteString("\\\"")
+        case ch == '\\': sb.WriteString("\\\\")
+        case ch == '\n': sb.WriteString("\\n")
+        case ch == '\r': sb.WriteString("\\r")
+        case ch == '\t': sb.WriteString("\\t")
+        case ch == 0:    sb.WriteString("\\0")
+        case !unicode.IsPrint(ch): fmt.Fprintf(&sb, "\\u{%X}", ch)
+        default:         sb.WriteRune(ch)
+        }
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}
+// FormatChar prints ch as a character literal.
+func FormatChar(ch rune) string {
+    for name, named := range CHAR_NAMES {
+        if named == ch { return "#\\" + name }
+    }
+    if !unicode.IsPrint(ch) { return fmt.Sprintf("#\\x%X", ch) }
+    return "#\\" + string(ch)
+}
+// formatDouble keeps a fractional part so the result still reads
+// back as a double.
+func formatDouble(d float64) string {
+    switch {
+    case math.IsNaN(d):     return "nan"
+    case math.IsInf(d, 1):  return "inf"
+    case math.IsInf(d, -1): return "-inf"
+    }
+    str := strconv.FormatFloat(d, 'g', -1, 64)
+    if strings.ContainsAny(str, ".e") { return str }
+    return str + ".0"
+}
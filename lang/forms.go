@@ -0,0 +1,433 @@
+package lang
+
+import (
+    "fmt"
+)
+
+// specialForm evaluates a whole (form ...) list itself, deciding which
+// of its arguments get evaluated. A form ending in an expression in tail
+// position returns that expression with the tailEnv to evaluate it in,
+// a nil tailEnv means res is already the value.
+type specialForm func(expr *Expr, env *Env) (res Expr, tailEnv *Env, err error)
+
+var SPECIAL_FORMS map[string]specialForm
+
+func init() {
+    // filled in init, the forms refer back to Eval
+    SPECIAL_FORMS = map[string]specialForm{
+        "define": evalDefine,
+        "set!":   evalSet,
+        "let":    evalLet,
+        "lambda": evalLambda,
+        "defn":   evalDefn,
+        "if":     evalIf,
+        "cond":   evalCond,
+        "when":   evalWhen,
+        "unless": evalWhen,
+        "and":    evalAnd,
+        "or":     evalOr,
+        "try":    evalTry,
+        "quote":      evalQuote,
+        "quasiquote": evalQuasiquote,
+        "unquote":          evalUnquote,
+        "unquote-splicing": evalUnquote,
+        "defmacro":      evalDefmacro,
+        "macroexpand":   evalMacroexpand,
+        "macroexpand-1": evalMacroexpand,
+        "require": evalRequire,
+        "import":  evalImport,
+        "export":  evalExport,
+    }
+}
+func IsSpecialForm(id string) bool {
+    _, ok := SPECIAL_FORMS[id]
+    return ok
+}
+
+func formError(expr *Expr, format string, args ...any) error {
+    return errorAt(expr.Range(), "%s: %s", expr.Args[0].Id, fmt.Sprintf(format, args...))
+}
+func nilExpr() Expr {
+    return Expr{Type: ExprNil}
+}
+// evalBody evaluates all but the last of exprs and leaves the last one
+// in tail position. An empty body is nil.
+func evalBody(exprs []Expr, env *Env) (res Expr, tailEnv *Env, err error) {
+    if len(exprs) == 0 { return nilExpr(), nil, nil }
+    for i := 0; i < len(exprs) - 1; i++ {
+        if _, err = exprs[i].Eval(env); err != nil { return }
+    }
+    return exprs[len(exprs) - 1], env, nil
+}
+// finish evaluates what a special form left in tail position.
+func finish(res Expr, tailEnv *Env, err error) (Expr, error) {
+    if err != nil || tailEnv == nil { return res, err }
+    return res.Eval(tailEnv)
+}
+
+// (define name value)
+func evalDefine(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 3 || expr.Args[1].Type != ExprId {
+        return res, nil, formError(expr, "expected (define name value)")
+    }
+    res, err = expr.Args[2].Eval(env)
+    if err != nil { return }
+    env.Define(expr.Args[1].Id, res)
+    return
+}
+// (set! name value)
+func evalSet(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 3 || expr.Args[1].Type != ExprId {
+        return res, nil, formError(expr, "expected (set! name value)")
+    }
+    name := &expr.Args[1]
+    res, err = expr.Args[2].Eval(env)
+    if err != nil { return }
+    if !env.Set(name.Id, res) {
+        err = errorAt(name.Range(), "set!: unbound identifier '%s'", name.Id)
+    }
+    return
+}
+// (let ((name value) ...) body...)
+// The values are evaluated in the outer scope, the body in a new one.
+func evalLet(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) < 2 || expr.Args[1].Type != ExprList {
+        return res, nil, formError(expr, "expected (let ((name value) ...) body...)")
+    }
+    scope := NewEnv(env)
+    for _, binding := range expr.Args[1].Args {
+        if binding.Type != ExprList || len(binding.Args) != 2 || binding.Args[0].Type != ExprId {
+            return res, nil, errorAt(binding.Range(), "let: binding must be (name value)")
+        }
+        var value Expr
+        value, err = binding.Args[1].Eval(env)
+        if err != nil { return }
+        scope.Define(binding.Args[0].Id, value)
+    }
+    return evalBody(expr.Args[2:], scope)
+}
+
+// Closure is a function defined in gosp code together with the scope
+// it was created in.
+type Closure struct {
+    Params []string
+    // name bound to the list of extra arguments, empty if not variadic
+    Rest   string
+    // declared parameter and result types, any where not annotated
+    Types   []FunctionType
+    Returns ExprType
+    Body   []Expr
+    Env    *Env
+    // where the closure was defined
+    Loc    Location
+}
+// parseClosure reads the parameter list at expr.Args[params] and the
+// body after it. Parameters are (a b & rest) or [a b & rest], each name
+// may be followed by its type, as in [a :int & rest :str], and a type
+// before the rest of the body declares the result: [a :int] :int body.
+func parseClosure(expr *Expr, params int, env *Env) (closure Closure, err error) {
+    form := expr.Args[0].Id
+    list := &expr.Args[params]
+    if list.Type != ExprList && list.Type != ExprVector {
+        return closure, errorAt(list.Range(), "%s: expected parameter list, got %s", form, list.Type.Str())
+    }
+    for i := 0; i < len(list.Args); i++ {
+        param := &list.Args[i]
+        if param.Type != ExprId {
+            return closure, errorAt(param.Range(), "%s: parameter must be an identifier, got %s", form, param.Type.Str())
+        }
+        rest := param.Id == "&"
+        if rest {
+            i += 1
+            if i >= len(list.Args) || list.Args[i].Type != ExprId {
+                return closure, errorAt(param.Range(), "%s: & must be followed by exactly one identifier", form)
+            }
+            param = &list.Args[i]
+        }
+        Type := ExprAny
+        if i + 1 < len(list.Args) && list.Args[i + 1].Type == ExprKeyword {
+            i += 1
+            if Type, err = annotation(form, &list.Args[i]); err != nil { return }
+        }
+        if !rest {
+            closure.Params = append(closure.Params, param.Id)
+            closure.Types  = append(closure.Types, FunctionType{Type: Type, QType: QuantityRegular})
+            continue
+        }
+        if i + 1 != len(list.Args) {
+            return closure, errorAt(list.Args[i + 1].Range(), "%s: & must be followed by exactly one identifier", form)
+        }
+        closure.Rest  = param.Id
+        closure.Types = append(closure.Types, FunctionType{Type: Type, QType: QuantityAny})
+    }
+    closure.Returns = ExprAny
+    closure.Body    = expr.Args[params + 1:]
+    if len(closure.Body) > 1 && closure.Body[0].Type == ExprKeyword {
+        if closure.Returns, err = annotation(form, &closure.Body[0]); err != nil { return }
+        closure.Body = closure.Body[1:]
+    }
+    closure.Env = env
+    closure.Loc = expr.Loc
+    return
+}
+// annotation reads a type written as a keyword, e.g. :int or :number.
+func annotation(form string, kw *Expr) (ExprType, error) {
+    for Type := ExprFunc; Type <= ExprAny; Type++ {
+        if Type.Str() == kw.Id { return Type, nil }
+    }
+    return ExprAny, errorAt(kw.Range(), "%s: unknown type :%s", form, kw.Id)
+}
+// makeFunction wraps a closure into a Function with its declared types.
+func makeFunction(id string, closure *Closure) *Function {
+    return &Function{
+        Id:      id,
+        Types:   closure.Types,
+        Returns: closure.Returns,
+        Closure: closure,
+        Impl: func(args []Expr) (res Expr, err error) {
+            res, err = finish(evalBody(closure.Body, closure.bind(args)))
+            if err != nil { return }
+            return res, closure.checkResult(id, res)
+        },
+    }
+}
+// checkResult fails if res doesn't have the declared result type.
+func (closure *Closure) checkResult(id string, res Expr) error {
+    if closure.Returns.Accepts(res.Type) { return nil }
+    return fmt.Errorf("'%s' must return %s, got %s", id, closure.Returns.Str(), res.Type.Str())
+}
+// bind creates the scope a call of the closure runs in.
+func (closure *Closure) bind(args []Expr) *Env {
+    scope := NewEnv(closure.Env)
+    for i, param := range closure.Params {
+        scope.Define(param, args[i])
+    }
+    if closure.Rest != "" {
+        rest := append([]Expr(nil), args[len(closure.Params):]...)
+        scope.Define(closure.Rest, seqExpr(ExprList, rest))
+    }
+    return scope
+}
+// (lambda (params...) [:type] body...)
+func evalLambda(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) < 2 {
+        return res, nil, formError(expr, "expected (lambda (params...) body...)")
+    }
+    closure, err := parseClosure(expr, 1, env)
+    if err != nil { return }
+    return Expr{Type: ExprFunc, Loc: expr.Loc, End: expr.End, Func: makeFunction("lambda", &closure)}, nil, nil
+}
+// (defn name (params...) [:type] body...)
+func evalDefn(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) < 3 || expr.Args[1].Type != ExprId {
+        return res, nil, formError(expr, "expected (defn name (params...) body...)")
+    }
+    closure, err := parseClosure(expr, 2, env)
+    if err != nil { return }
+    res = Expr{Type: ExprFunc, Loc: expr.Loc, End: expr.End, Func: makeFunction(expr.Args[1].Id, &closure)}
+    env.Define(expr.Args[1].Id, res)
+    return
+}
+
+func boolExpr(b bool) Expr {
+    return Expr{Type: ExprBool, Bool: b}
+}
+// (if test then [else])
+func evalIf(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 3 && len(expr.Args) != 4 {
+        return res, nil, formError(expr, "expected (if test then [else])")
+    }
+    test, err := expr.Args[1].Eval(env)
+    if err != nil { return }
+    if test.Truthy() { return expr.Args[2], env, nil }
+    if len(expr.Args) == 4 { return expr.Args[3], env, nil }
+    return nilExpr(), nil, nil
+}
+// (cond (test body...) ... (else body...))
+// A clause without a body yields the value of its test.
+func evalCond(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    for _, clause := range expr.Args[1:] {
+        if clause.Type != ExprList || len(clause.Args) == 0 {
+            return res, nil, errorAt(clause.Range(), "cond: clause must be (test body...)")
+        }
+        test := &clause.Args[0]
+        if test.Type == ExprId && test.Id == "else" {
+            return evalBody(clause.Args[1:], env)
+        }
+        res, err = test.Eval(env)
+        if err != nil { return }
+        if !res.Truthy() { continue }
+        if len(clause.Args) == 1 { return }
+        return evalBody(clause.Args[1:], env)
+    }
+    return nilExpr(), nil, nil
+}
+// (when test body...) and (unless test body...)
+func evalWhen(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) < 2 {
+        return res, nil, formError(expr, "expected (%s test body...)", expr.Args[0].Id)
+    }
+    test, err := expr.Args[1].Eval(env)
+    if err != nil { return }
+    if test.Truthy() == (expr.Args[0].Id == "when") {
+        return evalBody(expr.Args[2:], env)
+    }
+    return nilExpr(), nil, nil
+}
+// (and exprs...) stops at the first falsy value, (and) is #t.
+func evalAnd(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) == 1 { return boolExpr(true), nil, nil }
+    last := len(expr.Args) - 1
+    for i := 1; i < last; i++ {
+        res, err = expr.Args[i].Eval(env)
+        if err != nil || !res.Truthy() { return }
+    }
+    return expr.Args[last], env, nil
+}
+// (or exprs...) stops at the first truthy value, (or) is #f.
+func evalOr(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) == 1 { return boolExpr(false), nil, nil }
+    last := len(expr.Args) - 1
+    for i := 1; i < last; i++ {
+        res, err = expr.Args[i].Eval(env)
+        if err != nil || res.Truthy() { return }
+    }
+    return expr.Args[last], env, nil
+}
+// (try body... (catch name handler...))
+// Evaluates body, on an error evaluates handler with name bound to the
+// error value.
+func evalTry(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    last := &expr.Args[len(expr.Args) - 1]
+    if len(expr.Args) < 2 || !last.isCatch() {
+        return res, nil, formError(expr, "expected (try body... (catch name handler...))")
+    }
+    res, err = finish(evalBody(expr.Args[1:len(expr.Args) - 1], env))
+    if err == nil { return }
+    scope := NewEnv(env)
+    scope.Define(last.Args[1].Id, Expr{Type: ExprError, Loc: expr.Loc, End: expr.End, Err: err})
+    return evalBody(last.Args[2:], scope)
+}
+
+// isForm reports whether expr is a (name arg) list.
+func (expr *Expr) isForm(name string) bool {
+    return expr.Type == ExprList && len(expr.Args) == 2 &&
+           expr.Args[0].Type == ExprId && expr.Args[0].Id == name
+}
+// quoted turns code into data: map literals become map values with
+// their keys and values left unevaluated.
+func (expr *Expr) quoted() (res Expr, err error) {
+    switch (expr.Type) {
+    case ExprList: fallthrough
+    case ExprVector:
+        res = *expr
+        res.Args = make([]Expr, len(expr.Args))
+        for i := range expr.Args {
+            res.Args[i], err = expr.Args[i].quoted()
+            if err != nil { return }
+        }
+        return
+    case ExprMap:
+        if expr.Map != nil { return *expr, nil }
+        m := NewMap()
+        for i := 0; i + 1 < len(expr.Args); i += 2 {
+            var key, value Expr
+            if key, err = expr.Args[i].quoted(); err != nil { return }
+            if value, err = expr.Args[i + 1].quoted(); err != nil { return }
+            if err = m.Set(key, value); err != nil {
+                return res, errorAt(expr.Args[i].Range(), "%s", err.Error())
+            }
+        }
+        res = mapExpr(m)
+        res.Loc, res.End = expr.Loc, expr.End
+        return
+    }
+    return *expr, nil
+}
+// (quote expr) or 'expr
+func evalQuote(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 2 {
+        return res, nil, formError(expr, "expected (quote expr)")
+    }
+    res, err = expr.Args[1].quoted()
+    return
+}
+// (quasiquote expr) or `expr
+// Like quote, but ,x inside is evaluated and ,@xs is spliced into the
+// surrounding list or vector. Nested quasiquotes need as many extra
+// unquotes.
+func evalQuasiquote(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 2 {
+        return res, nil, formError(expr, "expected (quasiquote expr)")
+    }
+    res, err = quasiquote(&expr.Args[1], env, 1)
+    return
+}
+func quasiquote(tmpl *Expr, env *Env, depth int) (res Expr, err error) {
+    switch (tmpl.Type) {
+    case ExprList:
+        if tmpl.isForm("unquote") || tmpl.isForm("unquote-splicing") {
+            if depth == 1 {
+                if tmpl.Args[0].Id == "unquote" { return tmpl.Args[1].Eval(env) }
+                return res, errorAt(tmpl.Range(), "unquote-splicing outside of a list")
+            }
+            return quasiquoteNested(tmpl, env, depth - 1)
+        }
+        if tmpl.isForm("quasiquote") {
+            return quasiquoteNested(tmpl, env, depth + 1)
+        }
+        fallthrough
+    case ExprVector:
+        res = *tmpl
+        res.Args = nil
+        for i := range tmpl.Args {
+            item := &tmpl.Args[i]
+            if depth == 1 && item.isForm("unquote-splicing") {
+                var spliced Expr
+                spliced, err = item.Args[1].Eval(env)
+                if err != nil { return }
+                if spliced.Type != ExprList && spliced.Type != ExprVector && spliced.Type != ExprNil {
+                    return res, errorAt(item.Range(), "unquote-splicing expects a list or vector, got %s", spliced.Type.Str())
+                }
+                res.Args = append(res.Args, spliced.Args...)
+                continue
+            }
+            var value Expr
+            value, err = quasiquote(item, env, depth)
+            if err != nil { return }
+            res.Args = append(res.Args, value)
+        }
+        return
+    case ExprMap:
+        if tmpl.Map != nil { return *tmpl, nil }
+        flat := *tmpl
+        flat.Type = ExprVector
+        if flat, err = quasiquote(&flat, env, depth); err != nil { return }
+        if len(flat.Args) % 2 != 0 {
+            return res, errorAt(tmpl.Range(), "map template needs an even number of forms, got %d", len(flat.Args))
+        }
+        m := NewMap()
+        for i := 0; i < len(flat.Args); i += 2 {
+            if err = m.Set(flat.Args[i], flat.Args[i + 1]); err != nil {
+                return res, errorAt(tmpl.Range(), "%s", err.Error())
+            }
+        }
+        res = mapExpr(m)
+        res.Loc, res.End = tmpl.Loc, tmpl.End
+        return
+    }
+    return *tmpl, nil
+}
+// quasiquoteNested keeps a nested (quasiquote x) or (unquote x) form and
+// continues inside it at depth.
+func quasiquoteNested(tmpl *Expr, env *Env, depth int) (res Expr, err error) {
+    inner, err := quasiquote(&tmpl.Args[1], env, depth)
+    if err != nil { return }
+    res = *tmpl
+    res.Args = []Expr{tmpl.Args[0], inner}
+    return
+}
+// unquote and unquote-splicing only have meaning inside quasiquote.
+func evalUnquote(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    return res, nil, formError(expr, "used outside of quasiquote")
+}
@@ -0,0 +1,208 @@
+package lang
+
+import (
+    "errors"
+    "fmt"
+    "math/big"
+    "path/filepath"
+    "reflect"
+)
+
+// Interpreter runs gosp programs in a global scope of its own, Go code
+// extends it with Register.
+type Interpreter struct {
+    Env *Env
+}
+// NewInterpreter starts with the functions of DefaultRegistry.
+func NewInterpreter() *Interpreter {
+    return &Interpreter{Env: NewEnv(nil)}
+}
+// NewInterpreterWith provides exactly the functions of funcs, which
+// the interpreter registers into.
+func NewInterpreterWith(funcs *Registry) *Interpreter {
+    in := NewInterpreter()
+    in.Env.State.Funcs = funcs
+    return in
+}
+func (in *Interpreter) Funcs() *Registry {
+    return in.Env.State.Funcs
+}
+// RunString runs the program src, name is used in locations.
+func (in *Interpreter) RunString(name, src string) (Expr, error) {
+    l := LexerInit()
+    l.AddNamedExpr(name, src)
+    return l.ParseProgram(in.Env)
+}
+// SetModuleRoot lets programs require and import modules from files
+// under dir, without a root they can't load any.
+func (in *Interpreter) SetModuleRoot(dir string) error {
+    root, err := filepath.Abs(dir)
+    if err != nil { return err }
+    if resolved, err := filepath.EvalSymlinks(root); err == nil { root = resolved }
+    in.Env.State.ModuleRoot = root
+    return nil
+}
+// RunFiles runs the files at paths as one program. Unless a module
+// root is set, it is the directory of the first file.
+func (in *Interpreter) RunFiles(paths ...string) (res Expr, err error) {
+    if in.Env.State.ModuleRoot == "" && len(paths) > 0 {
+        if err = in.SetModuleRoot(filepath.Dir(paths[0])); err != nil { return }
+    }
+    l := LexerInit()
+    for _, path := range paths {
+        if err = l.AddSourceFile(path); err != nil { return }
+    }
+    return l.ParseProgram(in.Env)
+}
+
+var (
+    exprGoType  = reflect.TypeOf(Expr{})
+    errorGoType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Register adds the Go function fn as an overload of name. The
+// signature is derived from fn's parameters: integer types take int,
+// float types any number, string str, bool bool and Expr any value as
+// it is. A variadic last parameter takes any number of arguments.
+// fn may return nothing, a value, an error, or a value and an error,
+// results are converted back the same way, slices become vectors and
+// nil slices nil. Special forms can't be overloaded.
+func (in *Interpreter) Register(name string, fn any) error {
+    if IsSpecialForm(name) {
+        return fmt.Errorf("register %s: can't redefine special form '%s'", name, name)
+    }
+    _func, err := adaptFunc(name, fn)
+    if err != nil { return err }
+    return in.Funcs().Add(_func)
+}
+// adaptFunc builds a Function calling the Go function fn.
+func adaptFunc(name string, fn any) (*Function, error) {
+    value := reflect.ValueOf(fn)
+    if !value.IsValid() {
+        return nil, fmt.Errorf("register %s: expected a function, got nil", name)
+    }
+    goType := value.Type()
+    if goType.Kind() != reflect.Func {
+        return nil, fmt.Errorf("register %s: expected a function, got %s", name, goType)
+    }
+    if value.IsNil() {
+        return nil, fmt.Errorf("register %s: expected a function, got nil", name)
+    }
+    types := make([]FunctionType, goType.NumIn())
+    for i := range types {
+        param := goType.In(i)
+        if goType.IsVariadic() && i == len(types) - 1 { param = param.Elem() }
+        t, ok := exprTypeOf(param)
+        if !ok {
+            return nil, fmt.Errorf("register %s: unsupported parameter type %s", name, param)
+        }
+        types[i] = FunctionType{Type: t, QType: QuantityRegular}
+        if goType.IsVariadic() && i == len(types) - 1 { types[i].QType = QuantityAny }
+    }
+    n := goType.NumOut()
+    returnsErr := n > 0 && goType.Out(n - 1) == errorGoType
+    if returnsErr { n -= 1 }
+    if n > 1 {
+        return nil, fmt.Errorf("register %s: expected at most a value and an error as results", name)
+    }
+    if n == 1 && !isResultType(goType.Out(0)) {
+        return nil, fmt.Errorf("register %s: unsupported result type %s", name, goType.Out(0))
+    }
+    returns := ExprNil
+    if n == 1 { returns = resultType(goType.Out(0)) }
+    return &Function{
+        Id:      name,
+        Types:   types,
+        Returns: returns,
+        Impl: func(args []Expr) (res Expr, err error) {
+            in := make([]reflect.Value, len(args))
+            for i := range args {
+                param := goType.In(min(i, goType.NumIn() - 1))
+                if goType.IsVariadic() && i >= goType.NumIn() - 1 { param = param.Elem() }
+                in[i], err = toGo(args[i], param)
+                if err != nil {
+                    return res, fmt.Errorf("argument %d of '%s' %s", i + 1, name, err.Error())
+                }
+            }
+            out := value.Call(in)
+            if returnsErr {
+                if e := out[len(out) - 1]; !e.IsNil() { return res, e.Interface().(error) }
+            }
+            if n == 0 { return nilExpr(), nil }
+            return fromGo(out[0])
+        },
+    }, nil
+}
+// exprTypeOf is the ExprType a Go value of type t is converted from.
+func exprTypeOf(t reflect.Type) (ExprType, bool) {
+    if t == exprGoType { return ExprAny, true }
+    switch (t.Kind()) {
+    case reflect.Int,  reflect.Int8,  reflect.Int16,  reflect.Int32,  reflect.Int64,
+         reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return ExprInt, true
+    case reflect.Float32, reflect.Float64: return ExprNumber, true
+    case reflect.String: return ExprStr,  true
+    case reflect.Bool:   return ExprBool, true
+    }
+    return ExprAny, false
+}
+func isResultType(t reflect.Type) bool {
+    if t.Kind() == reflect.Slice { return isResultType(t.Elem()) }
+    _, ok := exprTypeOf(t)
+    return ok
+}
+// resultType is the ExprType fromGo converts a result of type t to.
+func resultType(t reflect.Type) ExprType {
+    switch (t.Kind()) {
+    case reflect.Uint, reflect.Uint64: return ExprNumber
+    case reflect.Float32, reflect.Float64: return ExprDouble
+    // nil slices are nil
+    case reflect.Slice: return ExprAny
+    }
+    if t == exprGoType { return ExprAny }
+    Type, _ := exprTypeOf(t)
+    return Type
+}
+// toGo converts a matched argument to the Go type t.
+func toGo(arg Expr, t reflect.Type) (v reflect.Value, err error) {
+    if t == exprGoType { return reflect.ValueOf(arg), nil }
+    v = reflect.New(t).Elem()
+    switch (t.Kind()) {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        if v.OverflowInt(arg.Int) { return v, fmt.Errorf("is out of range for %s", t) }
+        v.SetInt(arg.Int)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        if arg.Int < 0 || v.OverflowUint(uint64(arg.Int)) { return v, fmt.Errorf("is out of range for %s", t) }
+        v.SetUint(uint64(arg.Int))
+    case reflect.Float32, reflect.Float64:
+        v.SetFloat(arg.asDouble())
+    case reflect.String: v.SetString(arg.Str)
+    case reflect.Bool:   v.SetBool(arg.Bool)
+    }
+    return
+}
+// fromGo converts a result of a registered function to a value.
+func fromGo(v reflect.Value) (res Expr, err error) {
+    if v.Type() == exprGoType { return v.Interface().(Expr), nil }
+    switch (v.Kind()) {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return intExpr(v.Int()), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        if u := v.Uint(); u > uint64(^uint64(0) >> 1) {
+            return bigExpr(new(big.Int).SetUint64(u)), nil
+        }
+        return intExpr(int64(v.Uint())), nil
+    case reflect.Float32, reflect.Float64:
+        return doubleExpr(v.Float()), nil
+    case reflect.String: return Expr{Type: ExprStr,  Str:  v.String()}, nil
+    case reflect.Bool:   return boolExpr(v.Bool()), nil
+    case reflect.Slice:
+        if v.IsNil() { return nilExpr(), nil }
+        items := make([]Expr, v.Len())
+        for i := range items {
+            if items[i], err = fromGo(v.Index(i)); err != nil { return }
+        }
+        return vectorExpr(items), nil
+    }
+    return res, errors.New("unsupported result type " + v.Type().String())
+}
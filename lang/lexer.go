@@ -0,0 +1,856 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "os"
+    "fmt"
+    "errors"
+    "math"
+    "math/big"
+    "strconv"
+    "unicode"
+)
+
+func IsAlphaNum(ch rune) bool {
+    return unicode.IsLetter(ch) ||
+           unicode.IsDigit(ch)  || ch == '_'
+}
+
+var ID_CHARS_SPECIAL = []rune("+-/*.:_=!?<>|&")
+func IsIdFirst(ch rune) bool {
+    if unicode.IsLetter(ch) { return true }
+	for _, idCh := range ID_CHARS_SPECIAL {
+		if idCh == ch {
+			return true
+		}
+	}
+	return false
+}
+func IsId(ch rune) bool {
+    return IsIdFirst(ch) || unicode.IsDigit(ch)
+}
+
+type Location struct {
+    Source      string
+    Line        int
+    Column      int
+    
+    SourceIndex int
+    Raw         int
+}
+func (l *Location) Loc() string {
+    return fmt.Sprintf("%s:%d:%d", l.Source, l.Line, l.Column)
+}
+
+type TokenType uint8
+const (
+    TokenNone TokenType = iota
+    TokenId
+    TokenStr
+    TokenOParen
+    TokenCParen
+    TokenOCurly
+    TokenCCurly
+    TokenOBracket
+    TokenCBracket
+    TokenComma
+    TokenCommaAt
+    TokenQuote
+    TokenBackquote
+    TokenInt
+    TokenDouble
+    TokenBigInt
+    TokenRatio
+    TokenComment
+    TokenDatumComment
+    TokenBool
+    TokenNil
+    TokenChar
+    TokenKeyword
+    TokenError
+)
+func (t TokenType) OToC() TokenType {
+    switch (t) {
+    case TokenOParen:   return TokenCParen
+    case TokenOCurly:   return TokenCCurly
+    case TokenOBracket: return TokenCBracket
+    }
+    return TokenNone
+}
+func (t TokenType) CToO() TokenType {
+    switch (t) {
+    case TokenCParen:   return TokenOParen
+    case TokenCCurly:   return TokenOCurly
+    case TokenCBracket: return TokenOBracket
+    }
+    return TokenNone
+}
+func (t TokenType) ParenAlter() TokenType {
+    switch (t) {
+    case TokenOParen:   return TokenCParen
+    case TokenCParen:   return TokenOParen
+    case TokenOCurly:   return TokenCCurly
+    case TokenCCurly:   return TokenOCurly
+    case TokenOBracket: return TokenCBracket
+    case TokenCBracket: return TokenOBracket
+    }
+    return TokenNone
+}
+func (t TokenType) Str() string {
+    switch (t) {
+    case TokenNone:     return "none"
+    case TokenId:       return "id"
+    case TokenStr:      return "str"
+    case TokenOParen:   return "("
+    case TokenCParen:   return ")"
+    case TokenOCurly:   return "{"
+    case TokenCCurly:   return "}"
+    case TokenOBracket: return "["
+    case TokenCBracket: return "]"
+    case TokenComma:    return ","
+    case TokenCommaAt:  return ",@"
+    case TokenQuote:    return "'"
+    case TokenBackquote: return "`"
+    case TokenInt:      return "int"
+    case TokenDouble:   return "double"
+    case TokenBigInt:   return "bigint"
+    case TokenRatio:    return "ratio"
+    case TokenComment:  return "comment"
+    case TokenDatumComment: return "#;"
+    case TokenBool:     return "bool"
+    case TokenNil:      return "nil"
+    case TokenChar:     return "char"
+    case TokenKeyword:  return "keyword"
+    case TokenError:    return "error"
+    }
+    return "unknown"
+}
+
+type Source struct {
+    Name  string
+    Chars []rune
+    // read by AddSourceFile, Name is its path
+    File  bool
+}
+
+type Lexer struct {
+    Sources  []Source
+    Cursor   Location
+    TokenLoc Location
+    Type     TokenType
+    Str      string
+    Int      int64
+    Double   float64
+    Big      *big.Int
+    Rat      *big.Rat
+    Bool     bool
+    Char     rune
+    Err      error
+    ErrLoc   Location
+    NextFile bool
+    // KeepComments makes ParseToken return comments as trivia tokens,
+    // e.g. for a formatter that has to preserve them.
+    KeepComments bool
+}
+func LexerInit() (l Lexer) {
+    l.Cursor.SourceIndex   = -1
+    l.TokenLoc.SourceIndex = -1
+    l.Type                 = TokenNone
+    return
+}
+func (l *Lexer) AddSourceFile(src string) error {
+    bytes, err := os.ReadFile(src)
+    if err != nil { return err }
+    l.Sources = append(l.Sources, Source{
+        Name:  src,
+        Chars: []rune(string(bytes)),
+        File:  true,
+    })
+    if l.Cursor.SourceIndex == -1 {
+        l.Cursor.SourceIndex = 0
+        l.Cursor.Source      = src
+        l.Cursor.Line        = 1
+        l.Cursor.Column      = 1
+    }
+    return nil
+}
+func (l *Lexer) AddNamedExpr(name, value string) {
+    l.Sources = append(l.Sources, Source{
+        Name:  name,
+        Chars: []rune(value),
+    })
+    if l.Cursor.SourceIndex == -1 {
+        l.Cursor.SourceIndex = 0
+        l.Cursor.Source      = name
+        l.Cursor.Line        = 1
+        l.Cursor.Column      = 1
+    }
+}
+func (loc *Location) peekChar(l *Lexer) (ch rune, ok bool) {
+    if l.Cursor.SourceIndex == -1 { return }
+    if l.Cursor.SourceIndex >= len(l.Sources) { return }
+    if !l.nextSource() { return }
+    return l.Sources[loc.SourceIndex].Chars[loc.Raw], true
+}
+// nextSource moves the cursor past the sources it has read all of,
+// including empty ones. It is false if no characters are left.
+func (l *Lexer) nextSource() bool {
+    for l.Cursor.Raw >= len(l.Sources[l.Cursor.SourceIndex].Chars) {
+        if l.Cursor.SourceIndex + 1 >= len(l.Sources) { return false }
+        l.Cursor.SourceIndex += 1
+        l.Cursor.Source = l.Sources[l.Cursor.SourceIndex].Name
+        l.Cursor.Line   = 1
+        l.Cursor.Column = 1
+        l.Cursor.Raw    = 0
+        l.NextFile = true
+    }
+    return true
+}
+func (loc *Location) skipChar(l *Lexer, ch rune) (rest bool) {
+    Chars := l.Sources[loc.SourceIndex].Chars
+    if loc.Raw < len(Chars) {
+        if ch == '\n' {
+            loc.Line   += 1
+            loc.Column  = 1
+        } else { loc.Column += 1 }
+        loc.Raw  += 1
+    }
+    if loc.Raw >= len(Chars) {
+        if l.Cursor.SourceIndex + 1 >= len(l.Sources) { return }
+        return l.nextSource()
+    }
+    l.NextFile = false
+    return true
+}
+func (loc *Location) getChar(l *Lexer) (ch rune, ok bool) {
+    ch, ok = loc.peekChar(l)
+    if !ok { return }
+    loc.skipChar(l, ch)
+    return
+}
+func (l *Lexer) SkipSpaces() (ok bool) {
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok { break }
+        if !unicode.IsSpace(ch) { return true }
+        l.Cursor.skipChar(l, ch)
+    }
+    return
+}
+func (l *Lexer) setChToken(ch rune, kind TokenType) {
+    l.Cursor.skipChar(l, ch)
+    l.Type = kind
+    l.Char = ch
+}
+func (l *Lexer) unknownToken(ch rune) {
+    l.Cursor.skipChar(l, ch)
+    l.Type  = TokenError
+    Ch, _  := log.CharDesc(ch, false)
+    l.Err   = fmt.Errorf("%s does not start any known token", Ch)
+}
+func digitValue(ch rune) int {
+    switch {
+    case ch >= '0' && ch <= '9': return int(ch - '0')
+    case ch >= 'a' && ch <= 'z': return int(ch - 'a') + 10
+    case ch >= 'A' && ch <= 'Z': return int(ch - 'A') + 10
+    }
+    return -1
+}
+func radixName(base int) string {
+    switch (base) {
+    case 2:  return "binary"
+    case 8:  return "octal"
+    case 16: return "hexadecimal"
+    }
+    return "decimal"
+}
+func (l *Lexer) numberError(loc Location, format string, args ...any) bool {
+    l.Type   = TokenError
+    l.ErrLoc = loc
+    l.Err    = fmt.Errorf(format, args...)
+    // skip the rest of the malformed literal
+    for !l.NextFile {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || !IsId(ch) { break }
+        l.Cursor.skipChar(l, ch)
+    }
+    return true
+}
+// scanDigits reads digits of the given base, allowing single '_'
+// separators between them. ok is false once an error token was set.
+func (l *Lexer) scanDigits(base int, consumed *bool) (digits []rune, ok bool) {
+    for {
+        if *consumed && l.NextFile { return digits, true }
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok { return digits, true }
+        if ch == '_' {
+            if len(digits) == 0 { return digits, true }
+            sepLoc := l.Cursor
+            l.Cursor.skipChar(l, ch)
+            *consumed = true
+            ch, ok = l.Cursor.peekChar(l)
+            if l.NextFile || !ok || digitValue(ch) < 0 || digitValue(ch) >= base {
+                return nil, !l.numberError(sepLoc, "misplaced digit separator")
+            }
+            continue
+        }
+        value := digitValue(ch)
+        if value < 0 || !IsAlphaNum(ch) { return digits, true }
+        if value >= base {
+            if base == 10 { return digits, true }
+            Ch, _ := log.CharDesc(ch, false)
+            return nil, !l.numberError(l.Cursor, "invalid digit %s in %s literal", Ch, radixName(base))
+        }
+        digits = append(digits, ch)
+        l.Cursor.skipChar(l, ch)
+        *consumed = true
+    }
+}
+// parseNumber reads an optionally signed number literal:
+//     42  -7  +5  1_000_000  0xFF  0o755  0b1010
+//     1.5  .5  1.  1e-9  2.5E+3  inf  -inf  nan  1/3
+func (l *Lexer) parseNumber() bool {
+    saved := l.Cursor
+    consumed := false
+    var sign, intPart, fracPart, expSign, expPart, denominator []rune
+    var word []rune
+    var prefixLoc, expLoc, beforeSlash Location
+    var base int
+    var isFloating bool
+    var numStr string
+    var err error
+
+    peek := func() (rune, bool) {
+        if consumed && l.NextFile { return 0, false }
+        return l.Cursor.peekChar(l)
+    }
+    next := func(ch rune) {
+        l.Cursor.skipChar(l, ch)
+        consumed = true
+    }
+    // reports a literal running into letters or a dot
+    trailing := func() bool {
+        ch, ok := peek()
+        if !ok || !(IsAlphaNum(ch) || ch == '.') { return false }
+        Ch, _ := log.CharDesc(ch, false)
+        return l.numberError(l.Cursor, "unexpected %s in number literal", Ch)
+    }
+
+    ch, ok := peek()
+    if !ok { goto restore }
+    if ch == '+' || ch == '-' {
+        sign = append(sign, ch)
+        next(ch)
+        if ch, ok = peek(); !ok { goto restore }
+    }
+    if ch == 'i' || ch == 'n' {
+        for ok && IsId(ch) {
+            word = append(word, ch)
+            next(ch)
+            ch, ok = peek()
+        }
+        switch string(word) {
+        case "inf":
+            l.Type   = TokenDouble
+            l.Double = math.Inf(1)
+            if string(sign) == "-" { l.Double = math.Inf(-1) }
+            return true
+        case "nan":
+            l.Type   = TokenDouble
+            l.Double = math.NaN()
+            return true
+        }
+        goto restore
+    }
+    if ch == '0' {
+        prefixLoc = l.Cursor
+        next(ch)
+        ch, ok = peek()
+        switch {
+        case ok && (ch == 'x' || ch == 'X'): base = 16
+        case ok && (ch == 'o' || ch == 'O'): base = 8
+        case ok && (ch == 'b' || ch == 'B'): base = 2
+        default:
+            l.Cursor   = prefixLoc
+            l.NextFile = false
+            consumed   = len(sign) > 0
+        }
+    }
+    if base != 0 {
+        next(ch)
+        prefixLoc = l.Cursor
+        intPart, ok = l.scanDigits(base, &consumed)
+        if !ok { return true }
+        if len(intPart) == 0 {
+            return l.numberError(prefixLoc, "expected %s digits after 0%c", radixName(base), ch)
+        }
+        if trailing() { return true }
+        numStr = string(sign) + string(intPart)
+        goto integer
+    }
+    base = 10
+    if intPart, ok = l.scanDigits(base, &consumed); !ok { return true }
+    if ch, ok = peek(); ok && ch == '.' {
+        isFloating = true
+        next(ch)
+        if fracPart, ok = l.scanDigits(base, &consumed); !ok { return true }
+    }
+    if len(intPart) == 0 && len(fracPart) == 0 { goto restore }
+    if ch, ok = peek(); ok && (ch == 'e' || ch == 'E') {
+        isFloating = true
+        next(ch)
+        expLoc = l.Cursor
+        if ch, ok = peek(); ok && (ch == '+' || ch == '-') {
+            expSign = append(expSign, ch)
+            next(ch)
+        }
+        if expPart, ok = l.scanDigits(base, &consumed); !ok { return true }
+        if len(expPart) == 0 {
+            if consumed && l.NextFile { expLoc = l.TokenLoc }
+            return l.numberError(expLoc, "expected exponent digits")
+        }
+    }
+    // ratio literal, 1/3
+    beforeSlash = l.Cursor
+    if ch, ok = peek(); !isFloating && ok && ch == '/' {
+        next(ch)
+        denominator, ok = l.scanDigits(base, &consumed)
+        if !ok { return true }
+        if len(denominator) == 0 { l.Cursor = beforeSlash }
+    }
+    if trailing() { return true }
+
+    if len(intPart) == 0 { intPart = []rune("0") }
+    numStr = string(sign) + string(intPart)
+    if isFloating {
+        if len(fracPart) == 0 { fracPart = []rune("0") }
+        numStr += "." + string(fracPart)
+        if len(expPart) > 0 {
+            numStr += "e" + string(expSign) + string(expPart)
+        }
+        l.Type = TokenDouble
+        l.Double, err = strconv.ParseFloat(numStr, 64)
+        if errors.Is(err, strconv.ErrRange) {
+            err = fmt.Errorf("double literal out of range")
+        }
+        goto done
+    }
+    if len(denominator) > 0 {
+        l.Type = TokenRatio
+        l.Rat, ok = new(big.Rat).SetString(numStr + "/" + string(denominator))
+        if !ok { err = fmt.Errorf("zero denominator in ratio literal") }
+        goto done
+    }
+integer:
+    l.Type = TokenInt
+    l.Int, err = strconv.ParseInt(numStr, base, 64)
+    if errors.Is(err, strconv.ErrRange) {
+        l.Type = TokenBigInt
+        l.Big, _ = new(big.Int).SetString(numStr, base)
+        err = nil
+    }
+done:
+    if err != nil {
+        l.Type = TokenError
+        l.Err = err
+    }
+    return true
+restore:
+    l.Cursor = saved
+    return false
+}
+func (l *Lexer) parseId() bool {
+    saved := l.Cursor
+    ch, ok := l.Cursor.peekChar(l)
+    var chars []rune
+    if !ok { goto restore }
+    if !IsIdFirst(ch) { goto restore }
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok { break }
+        if !IsId(ch) { break }
+        chars = append(chars, ch)
+        l.Cursor.skipChar(l, ch)
+        if l.NextFile { break }
+    }
+    if len(chars) == 0 { goto restore }
+    l.Type = TokenId
+    l.Str  = string(chars)
+    if l.Str == "nil" {
+        l.Type = TokenNil
+    } else if chars[0] == ':' && len(chars) > 1 {
+        l.Type = TokenKeyword
+        l.Str  = string(chars[1:])
+    }
+    return true
+restore:
+    l.Cursor = saved
+    return false
+}
+func (l *Lexer) Loc() string {
+    return l.TokenLoc.Loc()
+}
+// readWord reads the alphanumeric run at the cursor.
+func (l *Lexer) readWord() string {
+    var chars []rune
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || !IsAlphaNum(ch) { break }
+        chars = append(chars, ch)
+        l.Cursor.skipChar(l, ch)
+        if l.NextFile { break }
+    }
+    return string(chars)
+}
+// parseBool reads the rest of #t, #f, #true or #false.
+func (l *Lexer) parseBool() {
+    switch l.readWord() {
+    case "t": fallthrough
+    case "true":
+        l.Type, l.Bool = TokenBool, true
+    case "f": fallthrough
+    case "false":
+        l.Type, l.Bool = TokenBool, false
+    default:
+        l.Type = TokenError
+        l.Err  = fmt.Errorf("invalid boolean literal, expected #t or #f")
+    }
+}
+var CHAR_NAMES = map[string]rune{
+    "nul":       0,
+    "alarm":     '\a',
+    "backspace": '\b',
+    "tab":       '\t',
+    "newline":   '\n',
+    "return":    '\r',
+    "escape":    rune(log.ESC),
+    "space":     ' ',
+    "delete":    rune(log.DEL),
+}
+// parseChar reads a character literal after "#\\": #\\a, #\\( or a
+// named one like #\\newline or #\\x41.
+func (l *Lexer) parseChar() {
+    ch, ok := l.Cursor.peekChar(l)
+    if !ok || l.NextFile {
+        l.Type = TokenError
+        l.Err  = fmt.Errorf("expected character after #\\")
+        return
+    }
+    if !unicode.IsLetter(ch) {
+        l.Cursor.skipChar(l, ch)
+        l.Type, l.Char = TokenChar, ch
+        return
+    }
+    name := []rune(l.readWord())
+    if len(name) == 1 {
+        l.Type, l.Char = TokenChar, name[0]
+        return
+    }
+    if ch, ok := CHAR_NAMES[string(name)]; ok {
+        l.Type, l.Char = TokenChar, ch
+        return
+    }
+    if name[0] == 'x' {
+        value := 0
+        for _, digit := range name[1:] {
+            d := digitValue(digit)
+            if d < 0 || d >= 16 || value > unicode.MaxRune {
+                value = -1
+                break
+            }
+            value = value*16 + d
+        }
+        if value > unicode.MaxRune { value = -1 }
+        if value >= 0 {
+            l.Type, l.Char = TokenChar, rune(value)
+            return
+        }
+    }
+    l.Type = TokenError
+    l.Err  = fmt.Errorf("unknown character name #\\%s", string(name))
+}
+// ParseToken reads the next token. Comments are skipped unless
+// KeepComments is set, in which case they come back as TokenComment.
+func (l *Lexer) ParseToken() bool {
+    for {
+        if !l.parseToken() { return false }
+        if l.Type != TokenComment || l.KeepComments { return true }
+    }
+}
+func (l *Lexer) parseLineComment() {
+    var chars []rune
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || ch == '\n' { break }
+        chars = append(chars, ch)
+        l.Cursor.skipChar(l, ch)
+        if l.NextFile { break }
+    }
+    l.Type = TokenComment
+    l.Str  = string(chars)
+}
+// parseBlockComment reads a #| ... |# comment, the opening "#|" is
+// already skipped. Block comments nest.
+func (l *Lexer) parseBlockComment() {
+    chars := []rune("#|")
+    depth := 1
+    var prev rune
+    for depth > 0 {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile {
+            l.Type = TokenError
+            l.Err  = fmt.Errorf("unclosed block comment")
+            return
+        }
+        l.Cursor.skipChar(l, ch)
+        chars = append(chars, ch)
+        if prev == '#' && ch == '|' {
+            depth += 1
+            ch = 0
+        } else if prev == '|' && ch == '#' {
+            depth -= 1
+            ch = 0
+        }
+        prev = ch
+    }
+    l.Type = TokenComment
+    l.Str  = string(chars)
+}
+func (l *Lexer) stringError(loc Location, format string, args ...any) {
+    l.Type   = TokenError
+    l.ErrLoc = loc
+    l.Err    = fmt.Errorf(format, args...)
+}
+// parseHex reads up to max hex digits, stopping early at stop.
+func (l *Lexer) parseHex(max int, stop rune) (value rune, n int) {
+    for n < max {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile || ch == stop { break }
+        digit := digitValue(ch)
+        if digit < 0 || digit >= 16 { break }
+        value = value*16 + rune(digit)
+        n += 1
+        l.Cursor.skipChar(l, ch)
+    }
+    return
+}
+// parseEscape reads the escape sequence after a backslash and returns
+// the character it stands for. skip is set for a backslash-newline
+// continuation, which produces no character.
+func (l *Lexer) parseEscape(loc Location) (ch rune, skip, ok bool) {
+    ch, ok = l.Cursor.peekChar(l)
+    if !ok || l.NextFile { return }
+    l.Cursor.skipChar(l, ch)
+    switch ch {
+    case '"':  fallthrough
+    case '\\': return ch, false, true
+    case 'r':  return '\r', false, true
+    case 'n':  return '\n', false, true
+    case 't':  return '\t', false, true
+    case '0':  return 0, false, true
+    case '\n':
+        // line continuation, leading whitespace of the next line is dropped
+        for {
+            next, ok := l.Cursor.peekChar(l)
+            if !ok || l.NextFile || next == '\n' || !unicode.IsSpace(next) { break }
+            l.Cursor.skipChar(l, next)
+        }
+        return 0, true, true
+    case 'x':
+        value, n := l.parseHex(2, 0)
+        if n != 2 {
+            l.stringError(loc, "\\x escape expects exactly 2 hex digits")
+            return 0, false, false
+        }
+        return value, false, true
+    case 'u':
+        if next, _ := l.Cursor.peekChar(l); next != '{' || l.NextFile {
+            l.stringError(loc, "\\u escape expects {HHHH}")
+            return 0, false, false
+        }
+        l.Cursor.skipChar(l, '{')
+        value, n := l.parseHex(6, '}')
+        next, _ := l.Cursor.peekChar(l)
+        if n == 0 || next != '}' || l.NextFile {
+            l.stringError(loc, "\\u escape expects 1 to 6 hex digits in braces")
+            return 0, false, false
+        }
+        l.Cursor.skipChar(l, next)
+        if value > unicode.MaxRune || (value >= 0xD800 && value <= 0xDFFF) {
+            l.stringError(loc, "\\u{%X} is not a valid code point", value)
+            return 0, false, false
+        }
+        return value, false, true
+    }
+    Ch, _ := log.CharDesc(ch, false)
+    l.stringError(loc, "%s unknown escape character", Ch)
+    return 0, false, false
+}
+// skipString skips the rest of a malformed string literal up to its
+// closing quote, so that it isn't taken for the opening of another.
+func (l *Lexer) skipString() {
+    escaped := false
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile { return }
+        l.Cursor.skipChar(l, ch)
+        if ch == '"' && !escaped { return }
+        escaped = ch == '\\' && !escaped
+    }
+}
+// parseString reads a string literal after its opening quote. Strings
+// may span several lines.
+func (l *Lexer) parseString() {
+    var chars []rune
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile {
+            l.stringError(l.TokenLoc, "unclosed string literal")
+            return
+        }
+        escapeLoc := l.Cursor
+        l.Cursor.skipChar(l, ch)
+        if ch == '"' { break }
+        if ch == '\\' {
+            var skip bool
+            ch, skip, ok = l.parseEscape(escapeLoc)
+            if l.Type == TokenError {
+                l.skipString()
+                return
+            }
+            if !ok {
+                l.stringError(l.TokenLoc, "unclosed string literal")
+                return
+            }
+            if skip { continue }
+        }
+        chars = append(chars, ch)
+    }
+    l.Type = TokenStr
+    l.Str  = string(chars)
+}
+// parseRawString reads a raw string, #"..."#, starting at the first '#'
+// after the dispatching one. No escapes are processed, extra '#'s around
+// the quotes allow the content to contain "#: ##"..."##.
+func (l *Lexer) parseRawString() {
+    hashes := 1
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile {
+            l.stringError(l.TokenLoc, "expected \" to start raw string")
+            return
+        }
+        l.Cursor.skipChar(l, ch)
+        if ch == '"' { break }
+        if ch != '#' {
+            Ch, _ := log.CharDesc(ch, false)
+            l.stringError(l.TokenLoc, "expected \" to start raw string, got %s", Ch)
+            return
+        }
+        hashes += 1
+    }
+    var chars []rune
+    for {
+        ch, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile {
+            l.stringError(l.TokenLoc, "unclosed raw string literal")
+            return
+        }
+        l.Cursor.skipChar(l, ch)
+        chars = append(chars, ch)
+        if ch != '#' { continue }
+        closing := len(chars) - hashes - 1
+        if closing < 0 || chars[closing] != '"' { continue }
+        matched := true
+        for i := closing + 1; i < len(chars); i++ {
+            if chars[i] != '#' { matched = false }
+        }
+        if !matched { continue }
+        chars = chars[:closing]
+        break
+    }
+    l.Type = TokenStr
+    l.Str  = string(chars)
+}
+func (l *Lexer) parseToken() bool {
+    ok := l.SkipSpaces()
+    if !ok { return false }
+    l.TokenLoc = l.Cursor
+    l.ErrLoc   = l.Cursor
+    ch, _ := l.Cursor.peekChar(l)
+    switch ch {
+    case ';':
+        l.parseLineComment()
+        return true
+    case '#':
+        l.Cursor.skipChar(l, ch)
+        next, ok := l.Cursor.peekChar(l)
+        if !ok || l.NextFile {
+            l.Type = TokenError
+            l.Err  = fmt.Errorf("# does not start any known token")
+            return true
+        }
+        switch next {
+        case '#': fallthrough
+        case '"':
+            l.parseRawString()
+        case '|':
+            l.Cursor.skipChar(l, next)
+            l.parseBlockComment()
+        case ';':
+            l.Cursor.skipChar(l, next)
+            l.Type = TokenDatumComment
+            l.Str  = "#;"
+        case 't': fallthrough
+        case 'f':
+            l.parseBool()
+        case '\\':
+            l.Cursor.skipChar(l, next)
+            l.parseChar()
+        default:
+            l.Type = TokenError
+            Ch, _ := log.CharDesc(next, false)
+            l.Err  = fmt.Errorf("#%s does not start any known token", Ch)
+        }
+        return true
+    case '(':
+        l.setChToken(ch, TokenOParen)
+        return true
+    case ')':
+        l.setChToken(ch, TokenCParen)
+        return true
+    case '{':
+        l.setChToken(ch, TokenOCurly)
+        return true
+    case '}':
+        l.setChToken(ch, TokenCCurly)
+        return true
+    case '[':
+        l.setChToken(ch, TokenOBracket)
+        return true
+    case ']':
+        l.setChToken(ch, TokenCBracket)
+        return true
+    case ',':
+        l.setChToken(ch, TokenComma)
+        if next, ok := l.Cursor.peekChar(l); ok && next == '@' && !l.NextFile {
+            l.setChToken(next, TokenCommaAt)
+        }
+        return true
+    case '\'':
+        l.setChToken(ch, TokenQuote)
+        return true
+    case '`':
+        l.setChToken(ch, TokenBackquote)
+        return true
+    case '"':
+        l.Cursor.skipChar(l, ch)
+        l.parseString()
+        return true
+    default:
+        if l.parseNumber() { return true }
+        if l.parseId()     { return true }
+        l.unknownToken(ch)
+        return true
+    }
+}
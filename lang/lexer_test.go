@@ -0,0 +1,95 @@
+package lang
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// lexOne reads src as a single token and prints it as type:value.
+func lexOne(src string) (string, bool) {
+    l := LexerInit()
+    l.AddNamedExpr("test", src)
+    if !l.ParseToken() { return "", false }
+    var value string
+    switch (l.Type) {
+    case TokenInt:    value = fmt.Sprintf("%d", l.Int)
+    case TokenBigInt: value = l.Big.String()
+    case TokenDouble: value = fmt.Sprintf("%g", l.Double)
+    case TokenRatio:  value = l.Rat.RatString()
+    case TokenId:     value = l.Str
+    case TokenError:  value = l.Err.Error()
+    }
+    token := l.Type.Str() + ":" + value
+    // the whole literal has to be one token
+    if l.Type != TokenError && l.ParseToken() { token += " " + l.Type.Str() }
+    return token, true
+}
+
+func TestNumberLiterals(t *testing.T) {
+    tests := []struct {
+        src, want string
+    }{
+        {"42",                       "int:42"},
+        {"-7",                       "int:-7"},
+        {"+5",                       "int:5"},
+        {"1_000_000",                "int:1000000"},
+        {"0xFF",                     "int:255"},
+        {"0XfF",                     "int:255"},
+        {"-0x10",                    "int:-16"},
+        {"0o755",                    "int:493"},
+        {"0b1010",                   "int:10"},
+        {"0xFF_FF",                  "int:65535"},
+        {"1.5",                      "double:1.5"},
+        {".5",                       "double:0.5"},
+        {"1.",                       "double:1"},
+        {"1e-9",                     "double:1e-09"},
+        {"2.5E+3",                   "double:2500"},
+        {"-inf",                     "double:-Inf"},
+        {"1/3",                      "ratio:1/3"},
+        {"-2/4",                     "ratio:-1/2"},
+        {"4/2",                      "ratio:2"},
+        {"9223372036854775807",      "int:9223372036854775807"},
+        {"9223372036854775808",      "bigint:9223372036854775808"},
+        {"-9223372036854775808",     "int:-9223372036854775808"},
+        {"0x1_0000_0000_0000_0000",  "bigint:18446744073709551616"},
+        {"0x",       "error:expected hexadecimal digits after 0x"},
+        {"0b102",    "error:invalid digit 2 in binary literal"},
+        {"0xFFg",    "error:invalid digit g in hexadecimal literal"},
+        {"0xFF.5",   "error:unexpected . in number literal"},
+        {"0b1.",     "error:unexpected . in number literal"},
+        {"1.5.2",    "error:unexpected . in number literal"},
+        {"12abc",    "error:unexpected a in number literal"},
+        {"1__0",     "error:misplaced digit separator"},
+        {"1_",       "error:misplaced digit separator"},
+        {"1e",       "error:expected exponent digits"},
+        {"1e+",      "error:expected exponent digits"},
+        {"1/0",      "error:zero denominator in ratio literal"},
+        {"1e999",    "error:double literal out of range"},
+    }
+    for _, test := range tests {
+        got, ok := lexOne(test.src)
+        if !ok {
+            t.Errorf("%q: no token", test.src)
+            continue
+        }
+        if got != test.want {
+            t.Errorf("%q: got %s, want %s", test.src, got, test.want)
+        }
+    }
+}
+
+func TestNumberLiteralsAcrossSources(t *testing.T) {
+    // a literal ends with its source, even if the next one continues it
+    l := LexerInit()
+    l.AddNamedExpr("a", "12")
+    l.AddNamedExpr("b", "")
+    l.AddNamedExpr("c", "34")
+    var tokens []string
+    for l.ParseToken() {
+        tokens = append(tokens, fmt.Sprintf("%s:%d", l.Type.Str(), l.Int))
+    }
+    if got := strings.Join(tokens, " "); got != "int:12 int:34" {
+        t.Errorf("got %s, want int:12 int:34", got)
+    }
+}
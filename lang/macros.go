@@ -0,0 +1,189 @@
+package lang
+
+// (defmacro name (params...) body...)
+// Like defn, but the body gets the argument forms unevaluated and
+// returns the code that replaces the call.
+func evalDefmacro(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) < 3 || expr.Args[1].Type != ExprId {
+        return res, nil, formError(expr, "expected (defmacro name (params...) body...)")
+    }
+    name := &expr.Args[1]
+    if IsSpecialForm(name.Id) {
+        return res, nil, errorAt(name.Range(), "defmacro: can't redefine special form '%s'", name.Id)
+    }
+    closure, err := parseClosure(expr, 2, env)
+    if err != nil { return }
+    fn := makeFunction(name.Id, &closure)
+    fn.Macro = true
+    res = Expr{Type: ExprFunc, Loc: expr.Loc, End: expr.End, Func: fn}
+    env.Define(name.Id, res)
+    return
+}
+// (macroexpand form) and (macroexpand-1 form)
+// Evaluate form and expand the macro call it is, macroexpand-1 once and
+// macroexpand until the head is no longer a macro. Subforms are left as
+// they are.
+func evalMacroexpand(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    if len(expr.Args) != 2 {
+        return res, nil, formError(expr, "expected (%s form)", expr.Args[0].Id)
+    }
+    res, err = expr.Args[1].Eval(env)
+    if err != nil { return }
+    for {
+        fn := env.macro(&res)
+        if fn == nil { return }
+        res, err = fn.expandMacro(&res)
+        if err != nil || expr.Args[0].Id == "macroexpand-1" { return }
+    }
+}
+
+// macro returns the macro called by the list expr, if it is a macro call.
+func (env *Env) macro(expr *Expr) *Function {
+    if expr.Type != ExprList || len(expr.Args) == 0 || expr.Args[0].Type != ExprId { return nil }
+    if IsSpecialForm(expr.Args[0].Id) { return nil }
+    value, ok := env.Lookup(expr.Args[0].Id)
+    if !ok || value.Type != ExprFunc || !value.Func.Macro { return nil }
+    return value.Func
+}
+// expandMacro expands the call of macro fn once. Errors name both the
+// call and the definition of the macro.
+func (fn *Function) expandMacro(call *Expr) (res Expr, err error) {
+    args := call.Args[1:]
+    err = fn.matchArgs(call.Range(), args)
+    if err == nil {
+        res, err = fn.Impl(args)
+    }
+    if err != nil {
+        return res, errorAt(call.Range(), "in expansion of macro '%s' defined at %s: %s", fn.Id, fn.Closure.Loc.Loc(), err.Error())
+    }
+    if res.Type == ExprList || res.Type == ExprVector || res.Type == ExprMap {
+        res.Loc, res.End = call.Loc, call.End
+    }
+    return
+}
+
+// Expand expands all macro calls in expr that are known in env, it runs
+// between ParseExpr and Eval. Quoted forms are left alone and names
+// bound by lambda, defn and let shadow macros in their bodies. Macros
+// defined while evaluating expr are expanded by Eval when it reaches
+// their calls.
+func (expr *Expr) Expand(env *Env) (res Expr, err error) {
+    state := env.State
+    if state.Depth >= state.MaxDepth {
+        return res, errorAt(expr.Range(), "macro expansion depth exceeded %d", state.MaxDepth)
+    }
+    state.Depth += 1
+    defer func() { state.Depth -= 1 }()
+    switch (expr.Type) {
+    case ExprList:
+        if fn := env.macro(expr); fn != nil {
+            res, err = fn.expandMacro(expr)
+            if err != nil { return }
+            return res.Expand(env)
+        }
+        if len(expr.Args) > 0 && expr.Args[0].Type == ExprId && IsSpecialForm(expr.Args[0].Id) {
+            return expr.expandForm(env)
+        }
+        fallthrough
+    case ExprVector:
+        return expr.expandFrom(0, env)
+    case ExprMap:
+        if expr.Map != nil { return *expr, nil }
+        return expr.expandFrom(0, env)
+    }
+    return *expr, nil
+}
+// Run evaluates a top-level form: expands its macros, then evaluates
+// the expansion.
+func (expr *Expr) Run(env *Env) (res Expr, err error) {
+    expanded, err := expr.Expand(env)
+    if err != nil { return }
+    return expanded.Eval(env)
+}
+// expandFrom expands the items of expr starting at index from.
+func (expr *Expr) expandFrom(from int, env *Env) (res Expr, err error) {
+    res = *expr
+    res.Args = append([]Expr(nil), expr.Args...)
+    for i := from; i < len(res.Args); i++ {
+        res.Args[i], err = expr.Args[i].Expand(env)
+        if err != nil { return }
+    }
+    return
+}
+// shadow returns a scope in which the identifiers of params are bound,
+// so they hide macros of the same name.
+func shadow(env *Env, params ...*Expr) *Env {
+    scope := NewEnv(env)
+    for _, param := range params {
+        if param.Type == ExprId { scope.Define(param.Id, nilExpr()) }
+        if param.Type == ExprList || param.Type == ExprVector {
+            for i := range param.Args {
+                if param.Args[i].Type == ExprId { scope.Define(param.Args[i].Id, nilExpr()) }
+            }
+        }
+    }
+    return scope
+}
+// expandForm expands the parts of a special form that are code. Forms
+// with an unexpected shape are left for Eval to report.
+func (expr *Expr) expandForm(env *Env) (res Expr, err error) {
+    args := expr.Args
+    switch (args[0].Id) {
+    case "quote": fallthrough
+    case "quasiquote": fallthrough
+    case "defmacro": fallthrough
+    case "import":   fallthrough
+    case "export":
+        return *expr, nil
+    case "define": fallthrough
+    case "set!":
+        return expr.expandFrom(2, env)
+    case "lambda":
+        if len(args) < 2 { break }
+        return expr.expandFrom(2, shadow(env, &args[1]))
+    case "defn":
+        if len(args) < 3 { break }
+        return expr.expandFrom(3, shadow(env, &args[1], &args[2]))
+    case "let":
+        if len(args) < 2 || args[1].Type != ExprList { break }
+        res = *expr
+        res.Args = append([]Expr(nil), args...)
+        bindings := args[1]
+        bindings.Args = append([]Expr(nil), args[1].Args...)
+        names := []*Expr{}
+        for i := range bindings.Args {
+            binding := &bindings.Args[i]
+            if binding.Type != ExprList || len(binding.Args) != 2 { continue }
+            if *binding, err = binding.expandFrom(1, env); err != nil { return }
+            names = append(names, &binding.Args[0])
+        }
+        res.Args[1] = bindings
+        return res.expandFrom(2, shadow(env, names...))
+    case "cond":
+        res = *expr
+        res.Args = append([]Expr(nil), args...)
+        for i := 1; i < len(res.Args); i++ {
+            if res.Args[i].Type != ExprList { continue }
+            if res.Args[i], err = res.Args[i].expandFrom(0, env); err != nil { return }
+        }
+        return
+    case "try":
+        last := &args[len(args) - 1]
+        if len(args) < 2 || !last.isCatch() { break }
+        body := *expr
+        body.Args = args[:len(args) - 1]
+        if res, err = body.expandFrom(1, env); err != nil { return }
+        // the catch clause is not a call, expand its handler only
+        var catch Expr
+        catch, err = last.expandFrom(2, shadow(env, &last.Args[1]))
+        res.Args = append(res.Args, catch)
+        return
+    }
+    return expr.expandFrom(1, env)
+}
+// isCatch reports whether expr is a (catch name handler...) clause.
+func (expr *Expr) isCatch() bool {
+    return expr.Type == ExprList && len(expr.Args) >= 2 &&
+           expr.Args[0].Type == ExprId && expr.Args[0].Id == "catch" &&
+           expr.Args[1].Type == ExprId
+}
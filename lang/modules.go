@@ -0,0 +1,154 @@
+package lang
+
+import (
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// MODULE_EXT is appended to module names given to import.
+const MODULE_EXT = ".gosp"
+
+// Module is a .gosp file loaded by require or import, evaluated once
+// in its own top-level scope.
+type Module struct {
+    Path    string
+    Env     *Env
+    // names listed by export, nil exports every top-level definition
+    Exports []string
+}
+func (m *Module) exported() []string {
+    if m.Exports != nil { return m.Exports }
+    names := make([]string, 0, len(m.Env.Vars))
+    for name := range m.Env.Vars {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// (require "path" [:as name])
+// Loads the file at path, relative to the file the form is in and
+// within the module root of the interpreter, and binds its exports in
+// the current scope, prefixed with name/ when given.
+func evalRequire(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    n := len(expr.Args)
+    if (n != 2 && n != 4) || expr.Args[1].Type != ExprStr {
+        return res, nil, formError(expr, "expected (require \"path\" [:as name])")
+    }
+    prefix, err := modulePrefix(expr, "")
+    if err != nil { return }
+    path := expr.Args[1].Str
+    return nilExpr(), nil, loadModule(expr, env, path, prefix)
+}
+// (import name [:as alias])
+// Loads name.gosp relative to the file the form is in, dots in name
+// separating directories, and binds its exports as name/export or
+// alias/export.
+func evalImport(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    n := len(expr.Args)
+    if (n != 2 && n != 4) || expr.Args[1].Type != ExprId {
+        return res, nil, formError(expr, "expected (import name [:as alias])")
+    }
+    name := expr.Args[1].Id
+    prefix, err := modulePrefix(expr, name)
+    if err != nil { return }
+    path := strings.ReplaceAll(name, ".", "/") + MODULE_EXT
+    return nilExpr(), nil, loadModule(expr, env, path, prefix)
+}
+// (export names...)
+// Limits what a module exposes to its importers.
+func evalExport(expr *Expr, env *Env) (res Expr, _ *Env, err error) {
+    root := env
+    for root.Parent != nil { root = root.Parent }
+    if root.Module == nil || env != root {
+        return res, nil, formError(expr, "only allowed at the top level of a module")
+    }
+    if root.Module.Exports == nil { root.Module.Exports = []string{} }
+    for _, name := range expr.Args[1:] {
+        if name.Type != ExprId {
+            return res, nil, errorAt(name.Range(), "export: expected identifier, got %s", name.Type.Str())
+        }
+        root.Module.Exports = append(root.Module.Exports, name.Id)
+    }
+    return nilExpr(), nil, nil
+}
+
+// modulePrefix reads the optional :as name of require and import.
+func modulePrefix(expr *Expr, name string) (prefix string, err error) {
+    if len(expr.Args) == 4 {
+        as, alias := &expr.Args[2], &expr.Args[3]
+        if as.Type != ExprKeyword || as.Id != "as" || alias.Type != ExprId {
+            return "", formError(expr, "expected :as name after the module")
+        }
+        name = alias.Id
+    }
+    if name == "" { return "", nil }
+    return name + "/", nil
+}
+// loadModule loads the module at path for the require or import form
+// expr, unless it was loaded before, and binds its exports in env.
+func loadModule(expr *Expr, env *Env, path, prefix string) error {
+    state := env.State
+    if state.ModuleRoot == "" {
+        return formError(expr, "modules are disabled, the interpreter has no module root")
+    }
+    if !state.Files[expr.Loc.Source] {
+        return formError(expr, "only allowed in files, %s is not one", expr.Loc.Source)
+    }
+    if filepath.IsAbs(path) {
+        return formError(expr, "%s: absolute paths are not allowed", path)
+    }
+    path = filepath.Clean(filepath.Join(filepath.Dir(expr.Loc.Source), path))
+    key, err := filepath.Abs(path)
+    if err != nil { return formError(expr, "%s: %s", path, err.Error()) }
+    // symlinks could point out of the root
+    if resolved, err := filepath.EvalSymlinks(key); err == nil { key = resolved }
+    if rel, err := filepath.Rel(state.ModuleRoot, key); err != nil || rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+        return formError(expr, "%s is outside of the module root %s", path, displayPath(state.ModuleRoot))
+    }
+    for i, loading := range state.Loading {
+        if loading.Path != key { continue }
+        chain := []string{}
+        for _, m := range state.Loading[i:] {
+            chain = append(chain, displayPath(m.Path))
+        }
+        chain = append(chain, displayPath(key))
+        return formError(expr, "cycle %s", strings.Join(chain, " -> "))
+    }
+    module, ok := state.Modules[key]
+    if !ok {
+        l := LexerInit()
+        if err = l.AddSourceFile(path); err != nil {
+            return formError(expr, "couldn't read %s: %s", path, err.Error())
+        }
+        module = &Module{Path: key}
+        module.Env = NewEnv(nil)
+        module.Env.State  = state
+        module.Env.Module = module
+        state.Loading = append(state.Loading, module)
+        _, err = l.ParseProgram(module.Env)
+        state.Loading = state.Loading[:len(state.Loading) - 1]
+        if err != nil {
+            if _, ok := err.(*LangError); ok { return err }
+            return formError(expr, "%s", err.Error())
+        }
+        state.Modules[key] = module
+    }
+    for _, name := range module.exported() {
+        value, ok := module.Env.Vars[name]
+        if !ok {
+            return formError(expr, "%s exports undefined '%s'", path, name)
+        }
+        env.Define(prefix + name, value)
+    }
+    return nil
+}
+// displayPath shortens path relative to the working directory.
+func displayPath(path string) string {
+    wd, err := filepath.Abs(".")
+    if err != nil { return path }
+    rel, err := filepath.Rel(wd, path)
+    if err != nil || strings.HasPrefix(rel, "..") { return path }
+    return rel
+}
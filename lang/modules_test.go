@@ -0,0 +1,81 @@
+package lang
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// writeFiles creates files under dir, path to content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+    t.Helper()
+    for path, content := range files {
+        path = filepath.Join(dir, path)
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { t.Fatal(err) }
+        if err := os.WriteFile(path, []byte(content), 0o644); err != nil { t.Fatal(err) }
+    }
+}
+
+func TestModules(t *testing.T) {
+    dir := t.TempDir()
+    root, outside := filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+    writeFiles(t, dir, map[string]string{
+        "root/lib/m.gosp":     "(define x 1) (define y 2)",
+        "root/lib/up.gosp":    "(require \"../lib/m.gosp\")",
+        "root/export.gosp":    "(export f) (define f 1) (define hidden 2)",
+        "root/undefined.gosp": "(export nope)",
+        "root/a.gosp":         "(require \"b.gosp\")",
+        "root/b.gosp":         "(require \"a.gosp\")",
+        "outside/secret.gosp": "(define secret 42)",
+    })
+    if err := os.Symlink(filepath.Join(outside, "secret.gosp"), filepath.Join(root, "link.gosp")); err != nil { t.Fatal(err) }
+    if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil { t.Fatal(err) }
+    abs := filepath.Join(outside, "secret.gosp")
+    tests := []evalTest{
+        {"(require \"lib/m.gosp\") (+ x y)",                 "int 3"},
+        {"(require \"lib/m.gosp\" :as m) m/y",               "int 2"},
+        {"(import lib.m) lib.m/x",                           "int 1"},
+        {"(import lib.m :as q) q/x",                         "int 1"},
+        {"(require \"lib/up.gosp\") 0",                      "int 0"},
+        // names a module binds may have any type
+        {"(define x \"a\") (require \"lib/m.gosp\") (+ x 1)", "int 2"},
+        {"(require \"export.gosp\") f",                      "int 1"},
+        {"(require \"export.gosp\") hidden",                 "error unbound identifier 'hidden'"},
+        {"(require \"undefined.gosp\")",                     "error exports undefined 'nope'"},
+        {"(require \"a.gosp\")",                             "error cycle"},
+        {"(require \"a.gosp\")",                             "error b.gosp -> "},
+        {"(require \"" + abs + "\")",                        "error absolute paths are not allowed"},
+        {"(require \"../outside/secret.gosp\")",             "error is outside of the module root"},
+        {"(require \"lib/../../outside/secret.gosp\")",      "error is outside of the module root"},
+        {"(require \"link.gosp\")",                          "error is outside of the module root"},
+        {"(require \"dir/secret.gosp\")",                    "error is outside of the module root"},
+        {"(require \"missing.gosp\")",                       "error couldn't read"},
+    }
+    for _, test := range tests {
+        writeFiles(t, root, map[string]string{"main.gosp": test.src})
+        in := NewInterpreter()
+        res, err := in.RunFiles(filepath.Join(root, "main.gosp"))
+        got := "error " + fmt.Sprint(err)
+        if err == nil { got = res.Type.Str() + " " + res.String() }
+        want, isErr := strings.CutPrefix(test.want, "error ")
+        if (isErr && !strings.Contains(got, want)) || (!isErr && got != test.want) {
+            t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+        }
+    }
+}
+
+func TestModulesOutsideFiles(t *testing.T) {
+    root := t.TempDir()
+    writeFiles(t, root, map[string]string{"m.gosp": "(define x 1)"})
+    // without a root, and from sources that aren't files like requests
+    in := NewInterpreter()
+    if _, err := in.RunString("post-request", "(require \"m.gosp\")"); err == nil || !strings.Contains(err.Error(), "modules are disabled") {
+        t.Errorf("got %v, want modules to be disabled", err)
+    }
+    if err := in.SetModuleRoot(root); err != nil { t.Fatal(err) }
+    if _, err := in.RunString("post-request", "(require \"m.gosp\")"); err == nil || !strings.Contains(err.Error(), "only allowed in files, post-request is not one") {
+        t.Errorf("got %v, want require to be refused", err)
+    }
+}
@@ -0,0 +1,228 @@
+package lang
+
+import (
+    "fmt"
+    "math"
+    "math/big"
+)
+
+func (expr *Expr) IsNumber() bool {
+    return ExprNumber.Accepts(expr.Type)
+}
+func (expr *Expr) asDouble() float64 {
+    switch (expr.Type) {
+    case ExprInt:    return float64(expr.Int)
+    case ExprBigInt:
+        d, _ := new(big.Float).SetInt(expr.Big).Float64()
+        return d
+    case ExprRatio:
+        d, _ := expr.Rat.Float64()
+        return d
+    }
+    return expr.Double
+}
+func (expr *Expr) asBig() *big.Int {
+    if expr.Type == ExprInt { return big.NewInt(expr.Int) }
+    return expr.Big
+}
+func (expr *Expr) asRat() *big.Rat {
+    switch (expr.Type) {
+    case ExprInt:    return new(big.Rat).SetInt64(expr.Int)
+    case ExprBigInt: return new(big.Rat).SetInt(expr.Big)
+    }
+    return expr.Rat
+}
+func (expr *Expr) sign() int {
+    switch (expr.Type) {
+    case ExprInt:
+        switch {
+        case expr.Int < 0: return -1
+        case expr.Int > 0: return  1
+        }
+        return 0
+    case ExprBigInt: return expr.Big.Sign()
+    case ExprRatio:  return expr.Rat.Sign()
+    }
+    switch {
+    case expr.Double < 0: return -1
+    case expr.Double > 0: return  1
+    }
+    return 0
+}
+func (expr *Expr) isZero() bool {
+    return expr.sign() == 0 && !math.IsNaN(expr.asDouble())
+}
+func intExpr(i int64) Expr {
+    return Expr{Type: ExprInt, Int: i}
+}
+func doubleExpr(d float64) Expr {
+    return Expr{Type: ExprDouble, Double: d}
+}
+// bigExpr and ratExpr normalize their result, so a number is only
+// ever as big as it has to be.
+func bigExpr(b *big.Int) Expr {
+    if b.IsInt64() { return intExpr(b.Int64()) }
+    return Expr{Type: ExprBigInt, Big: b}
+}
+func ratExpr(r *big.Rat) Expr {
+    if r.IsInt() { return bigExpr(new(big.Int).Set(r.Num())) }
+    return Expr{Type: ExprRatio, Rat: r}
+}
+
+// numLevel returns the smallest number type both a and b can be
+// represented in: int < bigint < ratio < double.
+func numLevel(a, b *Expr) ExprType {
+    rank := func(t ExprType) int {
+        switch (t) {
+        case ExprInt:    return 0
+        case ExprBigInt: return 1
+        case ExprRatio:  return 2
+        }
+        return 3
+    }
+    if rank(a.Type) > rank(b.Type) { return a.Type }
+    return b.Type
+}
+
+// Dividing by zero is an error for all of /, quot, rem and mod, the
+// zero double included, so doubles never divide to inf or nan.
+func divByZeroError() error {
+    return fmt.Errorf("division by zero")
+}
+
+// Arithmetic is carried out at the level of the widest operand,
+// int64 results that overflow are redone with big integers.
+func numAdd(a, b Expr) (Expr, error) {
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        r := a.Int + b.Int
+        if (r > a.Int) == (b.Int > 0) { return intExpr(r), nil }
+        fallthrough
+    case ExprBigInt: return bigExpr(new(big.Int).Add(a.asBig(), b.asBig())), nil
+    case ExprRatio:  return ratExpr(new(big.Rat).Add(a.asRat(), b.asRat())), nil
+    }
+    return doubleExpr(a.asDouble() + b.asDouble()), nil
+}
+func numSub(a, b Expr) (Expr, error) {
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        r := a.Int - b.Int
+        if (r < a.Int) == (b.Int > 0) { return intExpr(r), nil }
+        fallthrough
+    case ExprBigInt: return bigExpr(new(big.Int).Sub(a.asBig(), b.asBig())), nil
+    case ExprRatio:  return ratExpr(new(big.Rat).Sub(a.asRat(), b.asRat())), nil
+    }
+    return doubleExpr(a.asDouble() - b.asDouble()), nil
+}
+func numMul(a, b Expr) (Expr, error) {
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        if a.Int == 0 || b.Int == 0 { return intExpr(0), nil }
+        r := a.Int * b.Int
+        if r / b.Int == a.Int && !(a.Int == -1 && b.Int == math.MinInt64) && !(b.Int == -1 && a.Int == math.MinInt64) {
+            return intExpr(r), nil
+        }
+        fallthrough
+    case ExprBigInt: return bigExpr(new(big.Int).Mul(a.asBig(), b.asBig())), nil
+    case ExprRatio:  return ratExpr(new(big.Rat).Mul(a.asRat(), b.asRat())), nil
+    }
+    return doubleExpr(a.asDouble() * b.asDouble()), nil
+}
+// numDiv is exact unless one of the operands is a double.
+func numDiv(a, b Expr) (Expr, error) {
+    if b.isZero() { return Expr{}, divByZeroError() }
+    if numLevel(&a, &b) == ExprDouble { return doubleExpr(a.asDouble() / b.asDouble()), nil }
+    return ratExpr(new(big.Rat).Quo(a.asRat(), b.asRat())), nil
+}
+// numQuot truncates towards zero, numRem takes the sign of the dividend
+// and numMod the sign of the divisor.
+func numQuot(a, b Expr) (Expr, error) {
+    if b.isZero() { return Expr{}, divByZeroError() }
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        if !(a.Int == math.MinInt64 && b.Int == -1) { return intExpr(a.Int / b.Int), nil }
+        fallthrough
+    case ExprBigInt: return bigExpr(new(big.Int).Quo(a.asBig(), b.asBig())), nil
+    case ExprRatio:
+        x, y := a.asRat(), b.asRat()
+        num := new(big.Int).Mul(x.Num(), y.Denom())
+        den := new(big.Int).Mul(x.Denom(), y.Num())
+        return bigExpr(num.Quo(num, den)), nil
+    }
+    return doubleExpr(math.Trunc(a.asDouble() / b.asDouble())), nil
+}
+func numRem(a, b Expr) (Expr, error) {
+    if b.isZero() { return Expr{}, divByZeroError() }
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        if b.Int == -1 { return intExpr(0), nil }
+        return intExpr(a.Int % b.Int), nil
+    case ExprBigInt: return bigExpr(new(big.Int).Rem(a.asBig(), b.asBig())), nil
+    case ExprRatio:
+        q, _ := numQuot(a, b)
+        bq, _ := numMul(b, q)
+        return numSub(a, bq)
+    }
+    return doubleExpr(math.Mod(a.asDouble(), b.asDouble())), nil
+}
+func numMod(a, b Expr) (Expr, error) {
+    r, err := numRem(a, b)
+    if err != nil { return r, err }
+    if r.isZero() || (r.sign() < 0) == (b.sign() < 0) { return r, nil }
+    return numAdd(r, b)
+}
+func numNeg(a Expr) (Expr, error) {
+    return numSub(intExpr(0), a)
+}
+func numAbs(a Expr) (Expr, error) {
+    if a.Type == ExprDouble { return doubleExpr(math.Abs(a.Double)), nil }
+    if a.sign() >= 0 { return a, nil }
+    return numNeg(a)
+}
+// numCompare returns -1, 0 or 1. NaN compares as unordered and
+// yields ok == false.
+func numCompare(a, b Expr) (cmp int, ok bool) {
+    switch (numLevel(&a, &b)) {
+    case ExprInt:
+        switch {
+        case a.Int < b.Int: return -1, true
+        case a.Int > b.Int: return  1, true
+        }
+        return 0, true
+    case ExprBigInt: return a.asBig().Cmp(b.asBig()), true
+    case ExprRatio:  return a.asRat().Cmp(b.asRat()), true
+    }
+    x, y := a.asDouble(), b.asDouble()
+    switch {
+    case x < y:  return -1, true
+    case x > y:  return  1, true
+    case x == y: return  0, true
+    }
+    return 0, false
+}
+
+type numBinOp func(a, b Expr) (Expr, error)
+
+// foldNums applies op left to right, starting from init.
+func foldNums(init Expr, args []Expr, op numBinOp) (res Expr, err error) {
+    res = init
+    for _, arg := range args {
+        res, err = op(res, arg)
+        if err != nil { return }
+    }
+    return
+}
+// pickNum keeps the argument for which better(candidate, current) holds,
+// promoting to double if any argument is a double.
+func pickNum(args []Expr, better func(cmp int) bool) (res Expr, err error) {
+    res = args[0]
+    anyDouble := false
+    for _, arg := range args {
+        if arg.Type == ExprDouble { anyDouble = true }
+        cmp, ok := numCompare(arg, res)
+        if !ok { return doubleExpr(math.NaN()), nil }
+        if better(cmp) { res = arg }
+    }
+    if anyDouble { res = doubleExpr(res.asDouble()) }
+    return
+}
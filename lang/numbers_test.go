@@ -0,0 +1,104 @@
+package lang
+
+import (
+    "strings"
+    "testing"
+)
+
+// evalString runs the program src and prints its value as type value,
+// or the error it failed with.
+func evalString(src string) string {
+    res, err := NewInterpreter().RunString("test", src)
+    if err != nil { return "error " + err.Error() }
+    return res.Type.Str() + " " + res.String()
+}
+
+type evalTest struct {
+    src, want string
+}
+// checkEval compares the result of each program with want, errors only
+// have to contain it.
+func checkEval(t *testing.T, tests []evalTest) {
+    t.Helper()
+    for _, test := range tests {
+        got := evalString(test.src)
+        if strings.HasPrefix(test.want, "error ") && strings.Contains(got, strings.TrimPrefix(test.want, "error ")) { continue }
+        if got != test.want {
+            t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+        }
+    }
+}
+
+func TestNumericTower(t *testing.T) {
+    checkEval(t, []evalTest{
+        {"(+ 1 2)",       "int 3"},
+        {"(+ 1 2.5)",     "double 3.5"},
+        {"(* 2 1.5)",     "double 3.0"},
+        {"(- 1)",         "int -1"},
+        {"(/ 1.0 2)",     "double 0.5"},
+        {"(/ 6 3)",       "int 2"},
+        {"(quot 7 2)",    "int 3"},
+        {"(quot -7 2)",   "int -3"},
+        {"(rem -7 2)",    "int -1"},
+        {"(mod -7 2)",    "int 1"},
+        {"(mod 7 -2)",    "int -1"},
+        {"(quot 7.5 2)",  "double 3.0"},
+        {"(< 1 1.5 2)",   "bool #t"},
+        {"(= 1 1.0)",     "bool #t"},
+        {"(+ 1 \"a\")",   "error no overload of '+' takes (int str)"},
+    })
+}
+
+func TestDivisionByZero(t *testing.T) {
+    // every division builtin fails, whatever the number types
+    checkEval(t, []evalTest{
+        {"(/ 1 0)",       "error division by zero"},
+        {"(/ 1.0 0)",     "error division by zero"},
+        {"(/ 1 0.0)",     "error division by zero"},
+        {"(/ 1 -0.0)",    "error division by zero"},
+        {"(/ 0.0)",       "error division by zero"},
+        {"(/ 1/2 0)",     "error division by zero"},
+        {"(quot 1.0 0)",  "error division by zero"},
+        {"(rem 1 0)",     "error division by zero"},
+        {"(mod 1.5 0.0)", "error division by zero"},
+        {"(/ 1 nan)",     "double nan"},
+    })
+}
+
+func TestBigIntPromotion(t *testing.T) {
+    // int64 results that overflow become bigints, and go back to ints
+    // as soon as they fit
+    checkEval(t, []evalTest{
+        {"(+ 9223372036854775807 1)",       "bigint 9223372036854775808"},
+        {"(- -9223372036854775808 1)",      "bigint -9223372036854775809"},
+        {"(* 4294967296 4294967296)",       "bigint 18446744073709551616"},
+        {"(* -1 -9223372036854775808)",     "bigint 9223372036854775808"},
+        {"(- -9223372036854775808)",        "bigint 9223372036854775808"},
+        {"(quot -9223372036854775808 -1)",  "bigint 9223372036854775808"},
+        {"(rem -9223372036854775808 -1)",   "int 0"},
+        {"(- (+ 9223372036854775807 1) 1)", "int 9223372036854775807"},
+        {"(* 9223372036854775808 0)",       "int 0"},
+        {"(quot 18446744073709551616 2)",   "bigint 9223372036854775808"},
+        {"(+ 9223372036854775808 0.5)",     "double 9.223372036854776e+18"},
+    })
+}
+
+func TestRatios(t *testing.T) {
+    // division of integers is exact, ratios normalize to integers
+    checkEval(t, []evalTest{
+        {"(/ 1 3)",                   "ratio 1/3"},
+        {"(/ 2 4)",                   "ratio 1/2"},
+        {"(/ -2 4)",                  "ratio -1/2"},
+        {"(+ 1/3 2/3)",               "int 1"},
+        {"(* 1/3 3)",                 "int 1"},
+        {"(- 1/2 1/3)",               "ratio 1/6"},
+        {"(/ 1/2 1/4)",               "int 2"},
+        {"(+ 1/2 0.25)",              "double 0.75"},
+        {"(quot 7/2 1)",              "int 3"},
+        {"(rem 7/2 1)",               "ratio 1/2"},
+        {"(mod -7/2 1)",              "ratio 1/2"},
+        {"(< 1/3 0.34)",              "bool #t"},
+        {"(= 1/2 0.5)",               "bool #t"},
+        {"(/ 9223372036854775808 2)", "int 4611686018427387904"},
+    })
+}
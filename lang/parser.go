@@ -0,0 +1,529 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+)
+
+func (l *Lexer) PeekToken() (Type TokenType, ok bool) {
+    saved := l.Cursor
+    ok = l.ParseToken()
+    if ok {
+        Type = l.Type
+    }
+    l.Cursor = saved
+    return
+}
+func (l *Lexer) Expect(Type TokenType) error {
+    if l.Type != Type {
+        return fmt.Errorf("%s: Expected %s, got %s", l.Loc(), Type.Str(), l.Type.Str())
+    }
+    return nil
+}
+func (l *Lexer) ParseAndExpect(Type TokenType) error {
+    if !l.ParseToken() {
+        return fmt.Errorf("%s: Expected %s, got nothing", l.Loc(), Type.Str())
+    }
+    return l.Expect(Type)
+}
+// ExpectEOF fails unless only trivia is left in all of the sources.
+func (l *Lexer) ExpectEOF() (err error) {
+    if err = l.skipTrivia(); err != nil { return }
+    if _, ok := l.PeekToken(); ok {
+        err = fmt.Errorf("%s: Expected EOF", l.Loc())
+    }
+    return
+}
+// skipTrivia skips comment tokens and #; datum comments together with
+// the expression they comment out.
+func (l *Lexer) skipTrivia() error {
+    saved := l.Cursor
+    p := parser{l: l}
+    p.trivia()
+    if diags := p.done(); diags != nil {
+        l.Cursor = saved
+        return diags[0]
+    }
+    return nil
+}
+
+type ExprType uint8
+const (
+    ExprFunc ExprType = iota
+    ExprId
+    ExprStr
+    ExprInt
+    ExprDouble
+    ExprList
+    ExprNil
+    ExprBool
+    ExprError
+    ExprBigInt
+    ExprRatio
+    ExprChar
+    ExprKeyword
+    ExprVector
+    ExprMap
+
+    // Type classes, only used in FunctionType to accept several kinds.
+    ExprNumber
+    ExprColl
+    ExprAny
+)
+func (t ExprType) Str() string {
+    switch (t) {
+    case ExprFunc:   return "function"
+    case ExprId:     return "id"
+    case ExprStr:    return "str"
+    case ExprInt:    return "int"
+    case ExprDouble: return "double"
+    case ExprList:   return "list"
+    case ExprNil:    return "nil"
+    case ExprBool:   return "bool"
+    case ExprError:  return "error"
+    case ExprBigInt: return "bigint"
+    case ExprRatio:  return "ratio"
+    case ExprChar:   return "char"
+    case ExprKeyword: return "keyword"
+    case ExprVector: return "vector"
+    case ExprMap:    return "map"
+    case ExprColl:   return "collection"
+    case ExprNumber: return "number"
+    case ExprAny:    return "any"
+    }
+    return "unknown"
+}
+// Accepts reports whether a value of type other fits where t is expected.
+func (t ExprType) Accepts(other ExprType) bool {
+    switch (t) {
+    case ExprAny:    return true
+    case ExprNumber:
+        return other == ExprInt    || other == ExprDouble ||
+               other == ExprBigInt || other == ExprRatio
+    case ExprColl:
+        return other == ExprList   || other == ExprVector ||
+               other == ExprMap    || other == ExprStr
+    }
+    return t == other
+}
+type Expr struct {
+    Type   ExprType
+    Loc    Location
+    // where the source of the expression ends, values made while
+    // evaluating take the span of the expression they come from
+    End    Location
+    Func   *Function
+    // items of lists and vectors, map literals keep their flat key/value
+    // forms here until they are evaluated into Map
+    Args   []Expr
+    Map    *Map
+    Id     string
+    Str    string
+    Int    int64
+    Double float64
+    Big    *big.Int
+    Rat    *big.Rat
+    Bool   bool
+    Char   rune
+    Err    error
+}
+type QuantityType uint8
+const (
+    QuantityRegular QuantityType = iota
+    QuantityAny
+    QuantityRange
+)
+// QUANTITY_UNBOUNDED as FunctionType.To lets QuantityRange take
+// any number of arguments past From.
+const QUANTITY_UNBOUNDED = ^uint(0)
+
+// FunctionType describes one positional slot of a signature:
+// QuantityRegular takes exactly one argument, QuantityAny any number,
+// QuantityRange between From and To (inclusive).
+type FunctionType struct {
+    Type  ExprType
+    QType QuantityType
+    To    uint
+    From  uint
+}
+func (t FunctionType) arity() (min, max uint) {
+    switch (t.QType) {
+    case QuantityRegular: return 1, 1
+    case QuantityAny:     return 0, QUANTITY_UNBOUNDED
+    case QuantityRange:   return t.From, t.To
+    }
+    log.Unreachable("unknown QuantityType")
+    return
+}
+type Function struct {
+    Id      string
+    Types   []FunctionType
+    // type of the result, ExprAny when it depends on the arguments
+    Returns ExprType
+    Impl    func([]Expr) (Expr, error)
+    // set for functions defined in gosp code
+    Closure *Closure
+    // macros get their arguments unevaluated and return the code to
+    // evaluate in place of the call
+    Macro   bool
+    // set instead of Types and Impl when a Registry holds several
+    // functions of the same name
+    Overloads []*Function
+}
+func (f *Function) Arity() (min, max uint) {
+    for _, Type := range f.Types {
+        tMin, tMax := Type.arity()
+        min += tMin
+        if max == QUANTITY_UNBOUNDED || tMax == QUANTITY_UNBOUNDED {
+            max = QUANTITY_UNBOUNDED
+        } else {
+            max += tMax
+        }
+    }
+    return
+}
+func (f *Function) arityError(at Range, got int) error {
+    min, max := f.Arity()
+    var expected string
+    switch {
+    case min == max:                 expected = fmt.Sprintf("%d", min)
+    case max == QUANTITY_UNBOUNDED:  expected = fmt.Sprintf("at least %d", min)
+    default:                         expected = fmt.Sprintf("%d to %d", min, max)
+    }
+    plural := "s"
+    if min == 1 && (max == 1 || max == QUANTITY_UNBOUNDED) { plural = "" }
+    return errorAt(at, "'%s' expects %s argument%s, got %d", f.Id, expected, plural, got)
+}
+// matchArgs checks the arguments of the call spanning at against f.Types.
+// Slots are matched left to right, QuantityAny and QuantityRange take
+// as many arguments of their type as they can while leaving enough for
+// the slots after them.
+func (f *Function) matchArgs(at Range, args []Expr) error {
+    _, err := f.assignArgs(at, args)
+    return err
+}
+// assignArgs is matchArgs, also returning the type of the slot each
+// argument went to.
+func (f *Function) assignArgs(at Range, args []Expr) (slots []ExprType, err error) {
+    return f.assign(at, args, ExprType.Accepts)
+}
+// assign matches args to the slots of f, with accepts deciding whether
+// a slot takes an argument of a type.
+func (f *Function) assign(at Range, args []Expr, accepts func(slot, arg ExprType) bool) (slots []ExprType, err error) {
+    min, max := f.Arity()
+    if uint(len(args)) < min || uint(len(args)) > max {
+        return nil, f.arityError(at, len(args))
+    }
+    slots = make([]ExprType, len(args))
+    i := 0
+    restMin := min
+    var stopped *FunctionType
+    for j, Type := range f.Types {
+        tMin, tMax := Type.arity()
+        restMin -= tMin
+        if avail := uint(len(args) - i) - restMin; avail < tMax { tMax = avail }
+        var n uint
+        for n = 0; n < tMax && i < len(args); n, i = n + 1, i + 1 {
+            if !accepts(Type.Type, args[i].Type) {
+                stopped = &f.Types[j]
+                break
+            }
+            slots[i] = Type.Type
+        }
+        if n >= tMin { continue }
+        if i >= len(args) { return nil, f.arityError(at, len(args)) }
+        stopped = &f.Types[j]
+        break
+    }
+    if i < len(args) {
+        if stopped == nil { return nil, f.arityError(at, len(args)) }
+        return nil, errorAt(args[i].Range(), "argument %d of '%s' must be %s, got %s", i + 1, f.Id, stopped.Type.Str(), args[i].Type.Str())
+    }
+    return
+}
+// ParseProgram reads every top-level form of all sources, checks their
+// types, then expands and evaluates them in order in env. The value of
+// the last form is returned, an empty program is nil.
+func (l *Lexer) ParseProgram(env *Env) (res Expr, err error) {
+    forms, diags := l.ParseAll()
+    if diags != nil {
+        errs := make([]error, len(diags))
+        for i := range diags {
+            errs[i] = diags[i]
+        }
+        return res, errors.Join(errs...)
+    }
+    for _, source := range l.Sources {
+        if source.File { env.State.Files[source.Name] = true }
+    }
+    if errs := Check(env, forms); len(errs) > 0 {
+        return res, errors.Join(errs...)
+    }
+    res = nilExpr()
+    for i := range forms {
+        res, err = forms[i].Run(env)
+        if err != nil { return }
+    }
+    return
+}
+var READER_MACROS = map[TokenType]string{
+    TokenQuote:     "quote",
+    TokenBackquote: "quasiquote",
+    TokenComma:     "unquote",
+    TokenCommaAt:   "unquote-splicing",
+}
+
+// atom fills expr from the current token if it is a literal or an
+// identifier.
+func (l *Lexer) atom(expr *Expr) bool {
+    switch l.Type {
+    case TokenId:
+        expr.Type, expr.Id     = ExprId,     l.Str
+    case TokenStr:
+        expr.Type, expr.Str    = ExprStr,    l.Str
+    case TokenInt:
+        expr.Type, expr.Int    = ExprInt,    l.Int
+    case TokenDouble:
+        expr.Type, expr.Double = ExprDouble, l.Double
+    case TokenBigInt:
+        expr.Type, expr.Big    = ExprBigInt, l.Big
+    case TokenBool:
+        expr.Type, expr.Bool   = ExprBool,   l.Bool
+    case TokenChar:
+        expr.Type, expr.Char   = ExprChar,   l.Char
+    case TokenKeyword:
+        expr.Type, expr.Id     = ExprKeyword, l.Str
+    case TokenNil:
+        expr.Type              = ExprNil
+    case TokenRatio:
+        loc := expr.Loc
+        *expr    = ratExpr(l.Rat)
+        expr.Loc = loc
+    default:
+        return false
+    }
+    return true
+}
+// ParseExpr reads a single expression. Lists are kept as plain syntax,
+// functions are only looked up once the expression is evaluated. It
+// fails at the first problem in the expression, ParseAll reads on.
+func (l *Lexer) ParseExpr() (expr Expr, err error) {
+    saved := l.Cursor
+    p := parser{l: l}
+    expr, ok := p.form()
+    if diags := p.done(); diags != nil {
+        err = diags[0]
+    } else if !ok {
+        err = fmt.Errorf("%s: no token found", l.Loc())
+    }
+    if err != nil { l.Cursor = saved }
+    return
+}
+
+// parser reads forms for ParseAll, collecting a Diagnostic for each
+// problem instead of failing.
+type parser struct {
+    l     *Lexer
+    diags []Diagnostic
+    // forms being read, past MAX_EVAL_DEPTH deeper ones are skipped
+    depth int
+    // set when a skipped form runs to the end of the sources, the lists
+    // around it are not reported as unclosed again
+    skippedAll bool
+}
+func (p *parser) report(at Range, format string, args ...any) Diagnostic {
+    diag := Diagnostic{Severity: SeverityError, Range: at, Message: fmt.Sprintf(format, args...)}
+    p.diags = append(p.diags, diag)
+    return diag
+}
+// done returns the diagnostics in the order of the sources, unclosed
+// lists are only reported after their items.
+func (p *parser) done() []Diagnostic {
+    sort.SliceStable(p.diags, func(i, j int) bool {
+        a, b := p.diags[i].Range.Start, p.diags[j].Range.Start
+        if a.SourceIndex != b.SourceIndex { return a.SourceIndex < b.SourceIndex }
+        return a.Raw < b.Raw
+    })
+    return p.diags
+}
+// label adds a Label to the Diagnostic reported last.
+func (p *parser) label(at Range, format string, args ...any) {
+    diag := &p.diags[len(p.diags) - 1]
+    diag.Labels = append(diag.Labels, Label{Range: at, Message: fmt.Sprintf(format, args...)})
+}
+// token is the range of the token read last.
+func (p *parser) token() Range {
+    return Range{Start: p.l.TokenLoc, End: p.l.Cursor}
+}
+// broken reports a problem and returns the ExprError that stands for
+// the form that couldn't be read in the partial AST.
+func (p *parser) broken(at Range, format string, args ...any) Expr {
+    diag := p.report(at, format, args...)
+    return Expr{Type: ExprError, Loc: at.Start, End: at.End, Err: diag}
+}
+
+// ParseAll reads every form left in the sources. Unlike ParseExpr it
+// doesn't stop at the first problem: each one is reported and reading
+// goes on with the next form. What couldn't be read is an ExprError in
+// forms, so they are a partial AST even of a broken source.
+func (l *Lexer) ParseAll() (forms []Expr, diags []Diagnostic) {
+    p := parser{l: l}
+    for {
+        form, ok := p.form()
+        if !ok { break }
+        forms = append(forms, form)
+    }
+    return forms, p.done()
+}
+// form reads the next form, ok is false at the end of the sources.
+// Stray closing tokens are reported and skipped.
+func (p *parser) form() (expr Expr, ok bool) {
+    l := p.l
+    p.depth += 1
+    defer func() { p.depth -= 1 }()
+    for {
+        p.trivia()
+        if !l.ParseToken() { return }
+        expr.Loc = l.TokenLoc
+        if l.atom(&expr) {
+            expr.End = l.Cursor
+            return expr, true
+        }
+        if p.depth > MAX_EVAL_DEPTH && l.Type != TokenError && l.Type.CToO() == TokenNone {
+            at := p.token()
+            p.skip()
+            return p.broken(Range{Start: at.Start, End: l.Cursor}, "nesting depth exceeded %d", MAX_EVAL_DEPTH), true
+        }
+        switch (l.Type) {
+        case TokenError:
+            return p.broken(Range{Start: l.ErrLoc, End: l.Cursor}, "%s", l.Err.Error()), true
+        case TokenQuote:     fallthrough
+        case TokenBackquote: fallthrough
+        case TokenComma:     fallthrough
+        case TokenCommaAt:
+            opening, at := l.Type, p.token()
+            p.trivia()
+            if t, ok := l.PeekToken(); !ok || t.CToO() != TokenNone {
+                return p.broken(at, "expected expression after %s", opening.Str()), true
+            }
+            item, _ := p.form()
+            expr.Type, expr.End = ExprList, item.End
+            expr.Args = []Expr{
+                Expr{Type: ExprId, Loc: expr.Loc, End: at.End, Id: READER_MACROS[opening]},
+                item,
+            }
+            return expr, true
+        case TokenOParen:   fallthrough
+        case TokenOBracket: fallthrough
+        case TokenOCurly:
+            return p.list(expr), true
+        }
+        p.report(p.token(), "unexpected %s", l.Type.Str())
+    }
+}
+// skip reads past the rest of the form whose first token was read
+// last, without going deeper into it.
+func (p *parser) skip() {
+    l := p.l
+    depth := 0
+    for t := l.Type; ; t = l.Type {
+        switch {
+        case t.OToC() != TokenNone: depth += 1
+        case t.CToO() != TokenNone: depth -= 1
+        case depth == 0 && READER_MACROS[t] == "" && t != TokenDatumComment && t != TokenComment:
+            return
+        }
+        if depth == 0 && t.CToO() != TokenNone { return }
+        next, ok := l.PeekToken()
+        if !ok {
+            p.skippedAll = depth > 0
+            return
+        }
+        // a closing token after a reader macro belongs to the outer list
+        if depth == 0 && next.CToO() != TokenNone { return }
+        l.ParseToken()
+    }
+}
+// trivia skips comments, a datum comment missing its expression is
+// reported. Datum comments in a row take the forms after them in
+// reverse order.
+func (p *parser) trivia() {
+    l := p.l
+    var pending []Range
+    for {
+        t, ok := l.PeekToken()
+        if ok && (t == TokenComment || t == TokenDatumComment) {
+            l.ParseToken()
+            if t == TokenDatumComment { pending = append(pending, p.token()) }
+            continue
+        }
+        if len(pending) == 0 { return }
+        if !ok || t.CToO() != TokenNone {
+            for _, at := range pending {
+                p.report(at, "expected expression after #;")
+            }
+            return
+        }
+        pending = pending[:len(pending) - 1]
+        p.form()
+    }
+}
+// list reads the items of the list, vector or map whose opening token
+// was read last. Any closing token ends it, a mismatched one is
+// reported. If none is left, the list is taken to end before the first
+// opening token at the start of a later line or in another source, as
+// that is likely the next top-level form.
+func (p *parser) list(expr Expr) Expr {
+    l := p.l
+    opening, open := l.Type, p.token()
+    switch (opening) {
+    case TokenOParen:   expr.Type = ExprList
+    case TokenOBracket: expr.Type = ExprVector
+    case TokenOCurly:   expr.Type = ExprMap
+    }
+    // where to pick up if the list turns out to be unclosed
+    var resync *Location
+    var resyncArgs, resyncDiags int
+    for {
+        p.trivia()
+        l.SkipSpaces()
+        at := l.Cursor
+        t, ok := l.PeekToken()
+        if !ok {
+            if resync != nil {
+                l.Cursor = *resync
+                expr.Args, p.diags = expr.Args[:resyncArgs], p.diags[:resyncDiags]
+                p.skippedAll = false
+            }
+            expr.End = open.End
+            if n := len(expr.Args); n > 0 { expr.End = expr.Args[n - 1].End }
+            if p.skippedAll { return expr }
+            p.report(open, "unclosed %s", opening.Str())
+            if resync != nil { p.label(Range{Start: *resync, End: *resync}, "expected %s before this form", opening.OToC().Str()) }
+            return expr
+        }
+        if t.CToO() != TokenNone {
+            l.ParseToken()
+            expr.End = l.Cursor
+            if t != opening.OToC() {
+                p.report(p.token(), "expected %s to close %s from %s, got %s", opening.OToC().Str(), opening.Str(), open.Start.Loc(), t.Str())
+                p.label(open, "%s opened here", opening.Str())
+            }
+            break
+        }
+        newLine := at.Column == 1 && at.Line > open.Start.Line
+        if resync == nil && t.OToC() != TokenNone && (newLine || at.SourceIndex != open.Start.SourceIndex) {
+            resync = &at
+            resyncArgs, resyncDiags = len(expr.Args), len(p.diags)
+        }
+        item, _ := p.form()
+        expr.Args = append(expr.Args, item)
+    }
+    if expr.Type == ExprMap && len(expr.Args) % 2 != 0 {
+        p.report(open, "map literal needs an even number of forms, got %d", len(expr.Args))
+    }
+    return expr
+}
@@ -0,0 +1,114 @@
+package lang
+
+import (
+    "strings"
+    "testing"
+)
+
+// parseString reads every form of src with ParseAll.
+func parseString(src string) ([]Expr, []Diagnostic) {
+    l := LexerInit()
+    l.AddNamedExpr("test", src)
+    return l.ParseAll()
+}
+
+// parseAll prints the forms ParseAll reads from src, and the errors it
+// reports with their labels.
+func parseAll(src string) (forms, diags string) {
+    exprs, found := parseString(src)
+    printed := make([]string, len(exprs))
+    for i := range exprs {
+        printed[i] = exprs[i].String()
+    }
+    var errs []string
+    for _, diag := range found {
+        errs = append(errs, diag.Error())
+        for _, label := range diag.Labels {
+            errs = append(errs, label.Range.Start.Loc() + ": " + label.Message)
+        }
+    }
+    return strings.Join(printed, " "), strings.Join(errs, "; ")
+}
+
+func TestParseAll(t *testing.T) {
+    tests := []struct {
+        src, forms, diags string
+    }{
+        {"(define a \"bad \\q escape\")\n(define b \"ok\")\n(define c 3)",
+            "(define a #<error \"test:1:16: q unknown escape character\">) (define b \"ok\") (define c 3)",
+            "test:1:16: q unknown escape character"},
+        {"\"\\xZZ\" \"ok\"",
+            "#<error \"test:1:2: \\\\x escape expects exactly 2 hex digits\"> \"ok\"",
+            "test:1:2: \\x escape expects exactly 2 hex digits"},
+        {"\"\\u{110000} \\\"\" 1",
+            "#<error \"test:1:2: \\\\u{110000} is not a valid code point\"> 1",
+            "test:1:2: \\u{110000} is not a valid code point"},
+        // unclosed lists end before the next top-level form
+        {"(define a (+ 1 2)\n(define b 3)",
+            "(define a (+ 1 2)) (define b 3)",
+            "test:1:1: unclosed (; test:2:1: expected ) before this form"},
+        {"(a\n  (b c)\n(d e)",
+            "(a (b c)) (d e)",
+            "test:1:1: unclosed (; test:3:1: expected ) before this form"},
+        {"(a (b\n(c))",
+            "(a (b (c)))",
+            "test:1:1: unclosed ("},
+        {"(a b]",
+            "(a b)",
+            "test:1:5: expected ) to close ( from test:1:1, got ]; test:1:1: ( opened here"},
+        {"[1 2)",
+            "[1 2]",
+            "test:1:5: expected ] to close [ from test:1:1, got ); test:1:1: [ opened here"},
+        {") 1",
+            "1",
+            "test:1:1: unexpected )"},
+        {"(a 'b ')",
+            "(a (quote b) #<error \"test:1:7: expected expression after '\">)",
+            "test:1:7: expected expression after '"},
+        {"#; ) 1",
+            "1",
+            "test:1:1: expected expression after #;; test:1:4: unexpected )"},
+        {"{1 2 3}",
+            "{1 2 3}",
+            "test:1:1: map literal needs an even number of forms, got 3"},
+        {"(f 0xZZ 2)\n(g 1)",
+            "(f #<error \"test:1:6: invalid digit Z in hexadecimal literal\"> 2) (g 1)",
+            "test:1:6: invalid digit Z in hexadecimal literal"},
+    }
+    for _, test := range tests {
+        forms, diags := parseAll(test.src)
+        if forms != test.forms { t.Errorf("%q: got forms %s, want %s", test.src, forms, test.forms) }
+        if diags != test.diags { t.Errorf("%q: got %s, want %s", test.src, diags, test.diags) }
+    }
+}
+
+func TestParseExpr(t *testing.T) {
+    l := LexerInit()
+    l.AddNamedExpr("test", "(a b) c (d")
+    expr, err := l.ParseExpr()
+    if err != nil || expr.String() != "(a b)" { t.Fatalf("got %s %v, want (a b)", expr.String(), err) }
+    if expr, err = l.ParseExpr(); err != nil || expr.String() != "c" { t.Fatalf("got %s %v, want c", expr.String(), err) }
+    // a failed read leaves the cursor where it was
+    saved := l.Cursor
+    if _, err = l.ParseExpr(); err == nil || !strings.Contains(err.Error(), "unclosed (") {
+        t.Errorf("got %v, want unclosed (", err)
+    }
+    if l.Cursor != saved { t.Errorf("cursor moved from %s to %s", saved.Loc(), l.Cursor.Loc()) }
+}
+
+func TestNestingDepth(t *testing.T) {
+    // forms nested deeper than Eval could go are reported, not read on
+    // the Go stack
+    deep := MAX_EVAL_DEPTH + 1
+    checkEval(t, []evalTest{
+        {strings.Repeat("(", 30 * deep),                                        "error nesting depth exceeded"},
+        {strings.Repeat("'", 30 * deep) + "a",                                  "error nesting depth exceeded"},
+        {strings.Repeat("[", deep) + strings.Repeat("]", deep),                 "error nesting depth exceeded"},
+        {strings.Repeat("#; ", 30 * deep) + strings.Repeat("1 ", 30 * deep) + "2", "int 2"},
+    })
+    // the rest of the source is still read
+    _, diags := parseString(strings.Repeat("(", deep) + strings.Repeat(")", deep) + "\n(+ 1 2")
+    if len(diags) != 2 || !strings.Contains(diags[0].Message, "nesting depth exceeded") || diags[1].Message != "unclosed (" {
+        t.Errorf("got %v, want the nesting depth and the unclosed list", diags)
+    }
+}
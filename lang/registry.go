@@ -0,0 +1,213 @@
+package lang
+
+import (
+    "github.com/Fipaan/gosp/log"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Registry holds the functions an interpreter provides by name. A name
+// can have several overloads as long as their signatures differ.
+type Registry struct {
+    funcs map[string]*Function
+}
+func NewRegistry() *Registry {
+    return &Registry{funcs: map[string]*Function{}}
+}
+// DefaultRegistry is a new registry holding the BUILTINS.
+func DefaultRegistry() *Registry {
+    r := NewRegistry()
+    for i := range BUILTINS {
+        fn := BUILTINS[i]
+        if err := r.Add(&fn); err != nil { log.Unreachable("%s", err.Error()) }
+    }
+    return r
+}
+// Lookup returns the function registered as id, overloaded names give
+// a function holding all of their Overloads.
+func (r *Registry) Lookup(id string) *Function {
+    return r.funcs[id]
+}
+// Add registers fn as another overload of fn.Id. It fails if some call
+// could not choose between fn and an existing overload.
+func (r *Registry) Add(fn *Function) error {
+    prev, ok := r.funcs[fn.Id]
+    if !ok {
+        r.funcs[fn.Id] = fn
+        return nil
+    }
+    overloads := prev.overloads()
+    for _, other := range overloads {
+        if types := ambiguity(other, fn); types != nil {
+            return fmt.Errorf("%s is ambiguous with %s, both take (%s)", fn.Signature(), other.Signature(), joinTypes(types))
+        }
+    }
+    // copied, clones of the registry may share the old slice
+    overloads = append(append([]*Function(nil), overloads...), fn)
+    r.funcs[fn.Id] = &Function{Id: fn.Id, Overloads: overloads}
+    return nil
+}
+// Set registers fn as the only function named fn.Id.
+func (r *Registry) Set(fn *Function) {
+    r.funcs[fn.Id] = fn
+}
+func (r *Registry) Remove(id string) {
+    delete(r.funcs, id)
+}
+// Names lists the registered names in order.
+func (r *Registry) Names() []string {
+    names := make([]string, 0, len(r.funcs))
+    for name := range r.funcs {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+// Clone copies the registry, so one set of functions can be extended
+// for a single interpreter.
+func (r *Registry) Clone() *Registry {
+    clone := NewRegistry()
+    for name, fn := range r.funcs {
+        clone.funcs[name] = fn
+    }
+    return clone
+}
+
+func (f *Function) overloads() []*Function {
+    if f.Overloads != nil { return f.Overloads }
+    return []*Function{f}
+}
+// resolve picks the overload of f that accepts args and checks them.
+// When several do, the most specific one is called: the one whose slot
+// types are all within the slot types of the others.
+func (f *Function) resolve(at Range, args []Expr) (*Function, error) {
+    if f.Overloads == nil { return f, f.matchArgs(at, args) }
+    var best *Function
+    var bestSlots []ExprType
+    ambiguous := false
+    for _, fn := range f.Overloads {
+        slots, err := fn.assignArgs(at, args)
+        if err != nil { continue }
+        if best == nil {
+            best, bestSlots = fn, slots
+            continue
+        }
+        switch (compareSlots(slots, bestSlots)) {
+        case  1: best, bestSlots, ambiguous = fn, slots, false
+        case  0: ambiguous = true
+        }
+    }
+    types := make([]ExprType, len(args))
+    for i := range args {
+        types[i] = args[i].Type
+    }
+    if best == nil {
+        return nil, errorAt(at, "no overload of '%s' takes (%s), candidates: %s", f.Id, joinTypes(types), f.candidates())
+    }
+    if ambiguous {
+        return nil, errorAt(at, "ambiguous call of '%s' with (%s), candidates: %s", f.Id, joinTypes(types), f.candidates())
+    }
+    return best, nil
+}
+func (f *Function) candidates() string {
+    sigs := make([]string, len(f.Overloads))
+    for i, fn := range f.Overloads {
+        sigs[i] = fn.Signature()
+    }
+    return strings.Join(sigs, ", ")
+}
+func joinTypes(types []ExprType) string {
+    strs := make([]string, len(types))
+    for i, t := range types {
+        strs[i] = t.Str()
+    }
+    return strings.Join(strs, " ")
+}
+// within reports whether every value t takes is also taken by other.
+func (t ExprType) within(other ExprType) bool {
+    if t == other || other == ExprAny { return true }
+    return t != ExprAny && t != ExprNumber && t != ExprColl && other.Accepts(t)
+}
+// compareSlots is 1 if a is more specific than b, -1 if b is more
+// specific than a and 0 if neither is.
+func compareSlots(a, b []ExprType) int {
+    aWithin, bWithin := true, true
+    for i := range a {
+        aWithin = aWithin && a[i].within(b[i])
+        bWithin = bWithin && b[i].within(a[i])
+    }
+    switch {
+    case aWithin && !bWithin: return  1
+    case bWithin && !aWithin: return -1
+    }
+    return 0
+}
+// slotsFor gives the slot types of n arguments that are all accepted,
+// ok is false if f doesn't take n arguments.
+func (f *Function) slotsFor(n uint) (slots []ExprType, ok bool) {
+    min, max := f.Arity()
+    if n < min || n > max { return nil, false }
+    restMin := min
+    for _, Type := range f.Types {
+        tMin, tMax := Type.arity()
+        restMin -= tMin
+        if avail := n - uint(len(slots)) - restMin; avail < tMax { tMax = avail }
+        for k := uint(0); k < tMax; k++ {
+            slots = append(slots, Type.Type)
+        }
+    }
+    return slots, true
+}
+// ambiguity finds argument types both a and b take without one of them
+// being more specific, so that resolve couldn't choose. It returns nil
+// if there are none.
+func ambiguity(a, b *Function) []ExprType {
+    aMin, _ := a.Arity()
+    bMin, _ := b.Arity()
+    // past this the variadic slots only repeat
+    last := max(aMin, bMin) + uint(len(a.Types) + len(b.Types)) + 1
+    for n := uint(0); n <= last; n++ {
+        aSlots, aOk := a.slotsFor(n)
+        bSlots, bOk := b.slotsFor(n)
+        if !aOk || !bOk { continue }
+        both := make([]ExprType, n)
+        for i := range both {
+            switch {
+            case aSlots[i].within(bSlots[i]): both[i] = aSlots[i]
+            case bSlots[i].within(aSlots[i]): both[i] = bSlots[i]
+            default:                          both = nil
+            }
+            if both == nil { break }
+        }
+        if both != nil && compareSlots(aSlots, bSlots) == 0 { return both }
+    }
+    return nil
+}
+// Str prints the slot as it appears in a signature: int, int... for
+// any number, [int] for an optional one and int{1..3} for ranges.
+func (t FunctionType) Str() string {
+    name := t.Type.Str()
+    switch (t.QType) {
+    case QuantityRegular: return name
+    case QuantityAny:     return name + "..."
+    case QuantityRange:
+        switch {
+        case t.From == 0 && t.To == 1:       return "[" + name + "]"
+        case t.To == QUANTITY_UNBOUNDED:     return fmt.Sprintf("%s{%d..}", name, t.From)
+        default:                             return fmt.Sprintf("%s{%d..%d}", name, t.From, t.To)
+        }
+    }
+    log.Unreachable("unknown QuantityType")
+    return ""
+}
+// Signature prints f as a call pattern, e.g. (substr str int int).
+func (f *Function) Signature() string {
+    var sb strings.Builder
+    sb.WriteString("(" + f.Id)
+    for _, t := range f.Types {
+        sb.WriteString(" " + t.Str())
+    }
+    sb.WriteString(")")
+    return sb.String()
+}
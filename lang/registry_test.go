@@ -0,0 +1,108 @@
+package lang
+
+import (
+    "strings"
+    "testing"
+)
+
+// signature builds an overload of f taking the slots of types, written
+// as in signatures: int, int... for any number, [int] for an optional
+// one.
+func signature(types ...string) *Function {
+    fn := &Function{Id: "f"}
+    for _, name := range types {
+        slot := FunctionType{QType: QuantityRegular}
+        switch {
+        case strings.HasSuffix(name, "..."):
+            slot.QType, name = QuantityAny, strings.TrimSuffix(name, "...")
+        case strings.HasPrefix(name, "["):
+            slot.QType, slot.From, slot.To = QuantityRange, 0, 1
+            name = strings.Trim(name, "[]")
+        }
+        for t := ExprType(0); t.Str() != "unknown"; t++ {
+            if t.Str() == name { slot.Type = t }
+        }
+        fn.Types = append(fn.Types, slot)
+    }
+    return fn
+}
+
+func TestOverloadAmbiguity(t *testing.T) {
+    tests := []struct {
+        a, b []string
+        // the argument types both take, empty if calls can always tell
+        // them apart
+        both string
+    }{
+        {[]string{"int"},           []string{"str"},           ""},
+        {[]string{"int"},           []string{"number"},        ""},
+        {[]string{"int"},           []string{"any"},           ""},
+        {[]string{"int", "int"},    []string{"int"},           ""},
+        {[]string{"int"},           []string{"int"},           "(int)"},
+        {[]string{"int", "int"},    []string{"int..."},        "(int int)"},
+        {[]string{"int", "number"}, []string{"number", "int"}, "(int int)"},
+        {[]string{"[int]"},         []string{},                "()"},
+        {[]string{"str..."},        []string{"int..."},        "()"},
+        {[]string{"str", "str..."}, []string{"int", "int..."}, ""},
+        {[]string{"int", "str..."}, []string{"int", "int..."}, "(int)"},
+    }
+    for _, test := range tests {
+        r := NewRegistry()
+        a, b := signature(test.a...), signature(test.b...)
+        if err := r.Add(a); err != nil { t.Fatalf("%s: %v", a.Signature(), err) }
+        err := r.Add(b)
+        switch {
+        case test.both == "" && err != nil:
+            t.Errorf("%s and %s: unexpected error %v", a.Signature(), b.Signature(), err)
+        case test.both != "" && err == nil:
+            t.Errorf("%s and %s: expected them to be ambiguous", a.Signature(), b.Signature())
+        case test.both != "" && !strings.Contains(err.Error(), "both take " + test.both):
+            t.Errorf("%s and %s: got %v, want both take %s", a.Signature(), b.Signature(), err, test.both)
+        }
+    }
+}
+
+func TestOverloadResolution(t *testing.T) {
+    in := NewInterpreter()
+    overloads := []any{
+        func(x int) string       { return "int" },
+        func(x float64) string   { return "number" },
+        func(x string) string    { return "str" },
+        func(x Expr) string      { return "any" },
+        func(x, y int) string    { return "int int" },
+        func(x, y string) string { return "str str" },
+    }
+    for _, fn := range overloads {
+        if err := in.Register("describe", fn); err != nil { t.Fatal(err) }
+    }
+    tests := []struct {
+        src, want string
+    }{
+        {"(describe 1)",                   "int"},
+        {"(describe 9223372036854775808)", "number"},
+        {"(describe 1/2)",                 "number"},
+        {"(describe 1.5)",                 "number"},
+        {"(describe #t)",                  "any"},
+        {"(describe [1])",                 "any"},
+        {"(describe 1 2)",                 "int int"},
+        {"(describe \"a\" \"b\")",         "str str"},
+        {"(describe 1 \"b\")",             "no overload of 'describe' takes (int str)"},
+        {"(describe)",                     "no overload of 'describe' takes ()"},
+    }
+    for _, test := range tests {
+        res, err := in.RunString("test", test.src)
+        got := res.Str
+        if err != nil { got = err.Error() }
+        if !strings.Contains(got, test.want) {
+            t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+        }
+    }
+    // "describe" would have to be ambiguous about a second int
+    if err := in.Register("describe", func(x int, rest ...int) string { return "" }); err == nil {
+        t.Errorf("expected (describe int int...) to be ambiguous with (describe int)")
+    }
+    // special forms are evaluated before any function of their name
+    if err := in.Register("if", func(x int) int { return x }); err == nil {
+        t.Errorf("expected registering the special form if to fail")
+    }
+}
@@ -0,0 +1,115 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const TAB_WIDTH = 4
+
+// Span is a part of a source line a diagnostic points at. Lines and
+// columns count from 1, the span ends before EndColumn of EndLine. A
+// span reaching past Line is underlined to the end of Line, an empty
+// one as a single character.
+type Span struct {
+	Line, Column       int
+	EndLine, EndColumn int
+	Message            string
+}
+
+// Diagnostic is what PrintDiagnostic renders: the message, the lines
+// of Source the Primary span and the Labels are on, each span
+// underlined below its line, and the Notes after them.
+type Diagnostic struct {
+	Severity string
+	Message  string
+	Source   string
+	// all lines of Source, without them only the location is printed
+	Lines    []string
+	Primary  Span
+	Labels   []Span
+	Notes    []string
+}
+
+func severityColor(severity string) Color {
+	switch severity {
+	case "error":   return BrightRed
+	case "warning": return BrightYellow
+	}
+	return BrightCyan
+}
+// displayWidth is how many columns chars take once printed.
+func displayWidth(chars []rune) (width int) {
+	for _, ch := range chars {
+		if ch == '\t' { width += TAB_WIDTH } else { width += 1 }
+	}
+	return
+}
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", strings.Repeat(" ", TAB_WIDTH))
+}
+// underline marks span on line as mark followed by ~ under the rest of
+// it, after the columns before it.
+func (s Span) underline(line []rune, mark rune) (indent int, marks string) {
+	from := min(max(s.Column - 1, 0), len(line))
+	to   := from + 1
+	switch {
+	case s.EndLine > s.Line:                            to = len(line)
+	case s.EndLine == s.Line && s.EndColumn > s.Column: to = min(s.EndColumn - 1, len(line))
+	}
+	width := max(displayWidth(line[from:max(to, from)]), 1)
+	return displayWidth(line[:from]), string(mark) + strings.Repeat("~", width - 1)
+}
+
+// FprintDiagnostic renders d to w, e.g.
+//
+//	error: expected ) to close ( from main.gosp:1:11, got ]
+//	 --> main.gosp:1:17
+//	  |
+//	1 | (define x (+ 1 2]
+//	  |                 ^
+//	  |           - ( opened here
+func FprintDiagnostic(w io.Writer, d Diagnostic) {
+	color := severityColor(d.Severity)
+	fmt.Fprintf(w, "%s: %s\r\n", color.Colorf("%s", d.Severity), d.Message)
+	spans := append([]Span{d.Primary}, d.Labels...)
+	var lines []int
+	for _, s := range spans {
+		if s.Line >= 1 && s.Line <= len(d.Lines) { lines = append(lines, s.Line) }
+	}
+	sort.Ints(lines)
+	width := 1
+	if len(lines) > 0 { width = len(strconv.Itoa(lines[len(lines) - 1])) }
+	gutter := BrightBlue.Colorf("%*s |", width, "")
+	if d.Source != "" {
+		fmt.Fprintf(w, "%*s%s %s:%d:%d\r\n", width, "", BrightBlue.Colorf("-->"), d.Source, d.Primary.Line, d.Primary.Column)
+	}
+	if len(lines) > 0 { fmt.Fprintf(w, "%s\r\n", gutter) }
+	prev := 0
+	for _, n := range lines {
+		if n == prev { continue }
+		if prev != 0 && n > prev + 1 { fmt.Fprintf(w, "%s\r\n", BrightBlue.Colorf("...")) }
+		prev = n
+		line := []rune(strings.TrimSuffix(d.Lines[n - 1], "\r"))
+		fmt.Fprintf(w, "%s %s\r\n", BrightBlue.Colorf("%*d |", width, n), expandTabs(string(line)))
+		for i, s := range spans {
+			if s.Line != n { continue }
+			// the primary span is ^~~~, labels are -~~~
+			mark, markColor := '-', BrightBlue
+			if i == 0 { mark, markColor = '^', color }
+			indent, marks := s.underline(line, mark)
+			if s.Message != "" { marks += " " + s.Message }
+			fmt.Fprintf(w, "%s %*s%s\r\n", gutter, indent, "", markColor.Colorf("%s", marks))
+		}
+	}
+	for _, note := range d.Notes {
+		fmt.Fprintf(w, "%*s %s note: %s\r\n", width, "", BrightBlue.Colorf("="), note)
+	}
+}
+func PrintDiagnostic(d Diagnostic) {
+	FprintDiagnostic(os.Stderr, d)
+}
@@ -49,7 +49,7 @@ const (
 	US  = 31
 	DEL = 127
 )
-const ESC_STR = string(ESC)
+const ESC_STR = string(rune(ESC))
 
 type Color uint16
 const (
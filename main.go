@@ -1,11 +1,13 @@
 package main
 
 import (
+    "github.com/Fipaan/gosp/lang"
     "github.com/Fipaan/gosp/log"
     "github.com/Fipaan/gosp/utils"
     "fmt"
     "flag"
     "os"
+    "path/filepath"
 	"encoding/json"
 	"net/http"
 )
@@ -15,48 +17,74 @@ const (
 )
 
 func parseMain() {
-    var depth utils.Stack[TokenType]
+    var depth utils.Stack[lang.TokenType]
     const filename string = "main.go"
     var withPrefix bool   = false
-    l := LexerInit()
+    // problems are reported as they are found, reading goes on
+    var errors int
+    l := lang.LexerInit()
     err := l.AddSourceFile(filename)
     if err != nil {
         log.Abortf("Couldn't read %s: %s", filename, err.Error())
     }
     for l.ParseToken() {
-        if l.Type == TokenNone { return }
+        if l.Type == lang.TokenNone { return }
         tokenStr := ""
         withPrefix = true
         switch l.Type {
-        case TokenOParen: fallthrough
-        case TokenOCurly: fallthrough
-        case TokenOBracket:
+        case lang.TokenOParen: fallthrough
+        case lang.TokenOCurly: fallthrough
+        case lang.TokenOBracket:
             withPrefix = false
             depth.Push(l.Type)
             tokenStr = fmt.Sprintf("%c", l.Char)
-        case TokenCParen: fallthrough
-        case TokenCCurly: fallthrough
-        case TokenCBracket:
+        case lang.TokenCParen: fallthrough
+        case lang.TokenCCurly: fallthrough
+        case lang.TokenCBracket:
             t, ok := depth.Pop()
             if !ok || t != l.Type.CToO() {
-                log.Abortf("%s: unmatched paren", l.Loc())
+                l.PrintError(lang.Diagnostic{Range: lang.Range{Start: l.TokenLoc, End: l.Cursor}, Message: "unmatched paren"})
+                errors += 1
             }
             withPrefix = false
             tokenStr = fmt.Sprintf("%c", l.Char)
-        case TokenComma:
+        case lang.TokenComma: fallthrough
+        case lang.TokenQuote: fallthrough
+        case lang.TokenBackquote:
             withPrefix = false
             tokenStr = fmt.Sprintf("%c", l.Char)
-        case TokenStr:
+        case lang.TokenCommaAt:
+            withPrefix = false
+            tokenStr = ",@"
+        case lang.TokenStr:
             tokenStr = fmt.Sprintf("String(\"%s\")", log.Str2Printable(l.Str))
-        case TokenId:
+        case lang.TokenId:
             tokenStr = fmt.Sprintf("Id(%s)",         l.Str)
-        case TokenInt:
+        case lang.TokenInt:
             tokenStr = fmt.Sprintf("Int(%d)",        l.Int)
-        case TokenDouble:
+        case lang.TokenDouble:
             tokenStr = fmt.Sprintf("Double(%f)",     l.Double)
-        case TokenError:
-            log.Abortf("%s: %s", l.Loc(), l.Err.Error())
-        case TokenNone: fallthrough
+        case lang.TokenBigInt:
+            tokenStr = fmt.Sprintf("BigInt(%s)",     l.Big.String())
+        case lang.TokenRatio:
+            tokenStr = fmt.Sprintf("Ratio(%s)",      l.Rat.RatString())
+        case lang.TokenComment:
+            tokenStr = fmt.Sprintf("Comment(\"%s\")", log.Str2Printable(l.Str))
+        case lang.TokenDatumComment:
+            tokenStr = "DatumComment"
+        case lang.TokenBool:
+            tokenStr = fmt.Sprintf("Bool(%t)",       l.Bool)
+        case lang.TokenNil:
+            tokenStr = "Nil"
+        case lang.TokenChar:
+            tokenStr = fmt.Sprintf("Char(%s)",       lang.FormatChar(l.Char))
+        case lang.TokenKeyword:
+            tokenStr = fmt.Sprintf("Keyword(%s)",    l.Str)
+        case lang.TokenError:
+            l.PrintError(lang.Diagnostic{Range: lang.Range{Start: l.ErrLoc, End: l.Cursor}, Message: l.Err.Error()})
+            errors += 1
+            tokenStr = "Error"
+        case lang.TokenNone: fallthrough
         default: log.Unreachable("unknown TokenType")
         }
         if withPrefix {
@@ -65,25 +93,38 @@ func parseMain() {
         log.Printf("%s", tokenStr)
     }
     if !withPrefix { log.Printf("\r\n") }
+    if errors > 0 {
+        log.Errorf("Read %s with %d errors", filename, errors)
+        return
+    }
     log.Infof("Successfully read %s!", filename)
 }
 
 func fileExample() {
     _filename := flag.String("filename", "main.go", "path to file to be parsed")
+    flag.IntVar(&lang.MAX_EVAL_DEPTH, "max-depth", lang.MAX_EVAL_DEPTH, "maximum nesting of evaluation")
     flag.Parse()
-    filename := *_filename
-    l := LexerInit()
-    err := l.AddSourceFile(filename)
-    if err != nil {
-        log.Abortf("Couldn't read %s: %s", filename, err.Error())
+    // further files are read after it, as one program
+    paths := append([]string{*_filename}, flag.Args()...)
+    l := lang.LexerInit()
+    for _, path := range paths {
+        if err := l.AddSourceFile(path); err != nil {
+            log.Errorf("Couldn't read %s: %s", path, err.Error())
+            os.Exit(1)
+        }
+    }
+    in := lang.NewInterpreter()
+    if err := in.SetModuleRoot(filepath.Dir(*_filename)); err != nil {
+        log.Errorf("Couldn't use %s as module root: %s", filepath.Dir(*_filename), err.Error())
+        os.Exit(1)
     }
-    var expr Expr
-    expr, err = l.ParseExpr()
+    res, err := l.ParseProgram(in.Env)
     if err != nil {
-        log.Errorf("%s", err.Error())
+        // every problem found is reported, not only the first
+        l.PrintError(err)
         os.Exit(1)
     }
-    log.Printf("%s", expr.Eval())
+    log.Printf("%s", res.String())
 }
 
 type ExprRequest struct {
@@ -92,6 +133,7 @@ type ExprRequest struct {
 
 type ExprResponse struct {
 	Result string `json:"result,omitempty"`
+	Type   string `json:"type,omitempty"`
 	Error  string `json:"error,omitempty"`
 }
 
@@ -112,14 +154,12 @@ func exprHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
     
-    l := LexerInit()
-    l.AddNamedExpr("post-request", req.Expr)
-    expr, err := l.ParseExpr()
+    res, err := lang.NewInterpreter().RunString("post-request", req.Expr)
     if err != nil {
 		writeJSON(w, ExprResponse{Error: err.Error()})
 		return
     }
-	writeJSON(w, ExprResponse{Result: expr.Eval()})
+	writeJSON(w, ExprResponse{Result: res.String(), Type: res.Type.Str()})
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
@@ -20,7 +20,7 @@ document.getElementById("runBtn").addEventListener("click", async () => {
     if (data.error) {
       output.textContent = `Error: ${data.error}`;
     } else if (data.result) {
-      output.textContent = `Result: ${data.result}`;
+      output.textContent = `Result (${data.type}): ${data.result}`;
     } else {
       output.textContent = `Unknown response: ${JSON.stringify(data)}`;
     }